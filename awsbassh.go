@@ -3,62 +3,147 @@ package main
 import (
 	"aws-bassh/pkg/connect"
 	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/inventory"
+	"aws-bassh/pkg/lifecycle"
 	"aws-bassh/pkg/loader"
 	"aws-bassh/pkg/model"
 	"aws-bassh/pkg/output"
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
-func initialize(awsProfile string) bool {
-	return ec2client.Initialize(awsProfile) == nil
+func initialize(ctx context.Context, awsProfile string, awsOptions model.AwsOptions) *ec2client.Session {
+	session, err := ec2client.Initialize(ctx, awsProfile, awsOptions)
+
+	if err != nil {
+		return nil
+	}
+
+	return session
 }
 
-func runGenerate() bool {
+func runGenerate(ctx context.Context) bool {
 	generateConfig := model.MakeCommandLineGenerateConfig()
 
-	if !initialize(generateConfig.AwsProfile) {
+	if !generateConfig.Validate() {
+		return false
+	}
+
+	if generateConfig.IsMultiProfile() {
+		return runGenerateProfiles(ctx, generateConfig)
+	}
+
+	session := initialize(ctx, generateConfig.AwsProfile, generateConfig.AwsOptions)
+
+	if session == nil {
 		return false
 	}
 
 	log.Printf("Generate config %+v\n", generateConfig)
 
-	machines, err := loader.LoadAllMachines(generateConfig)
+	inventory := inventory.Open(generateConfig.InventoryFile)
+	machines, err := loader.LoadAllMachines(session, inventory, generateConfig)
 
 	if err != nil {
 		return false
 	}
 
+	inventory.Save()
 	return output.WriteMachines(generateConfig, machines)
 }
 
-func runConnect() bool {
-	connectConfig := model.MakeCommandLineConnectConfig()
+func runGenerateProfiles(ctx context.Context, generateConfig model.GenerateConfig) bool {
+	log.Printf("Generate config %+v\n", generateConfig)
+
+	inventory := inventory.Open(generateConfig.InventoryFile)
+	profiles, err := loader.LoadAllProfiles(ctx, inventory, generateConfig)
 
-	if !initialize(connectConfig.AwsProfile) {
+	if err != nil {
 		return false
 	}
 
+	inventory.Save()
+
+	if !output.CreateProfilesDir(generateConfig) {
+		return false
+	}
+
+	files := []string{}
+	success := true
+
+	for _, profile := range profiles {
+		if profile.Err != nil {
+			log.Printf("Skipping profile %v, %v", profile.Config.AwsProfile, profile.Err)
+			success = false
+			continue
+		}
+
+		if !output.WriteMachines(profile.Config, profile.Machines) {
+			success = false
+			continue
+		}
+
+		files = append(files, profile.Config.OutputFile)
+	}
+
+	return output.WriteIndexFile(generateConfig, files) && success
+}
+
+func runConnect(ctx context.Context) bool {
+	connectConfig := model.MakeCommandLineConnectConfig()
+
 	log.Printf("Connect config %+v\n", connectConfig)
 
-	return connect.SSH(connectConfig)
+	return connect.SSH(ctx, inventory.Open(connectConfig.InventoryFile), connectConfig)
+}
+
+func runLifecycle(ctx context.Context, action string) bool {
+	lifecycleConfig := model.MakeCommandLineLifecycleConfig(action)
+
+	log.Printf("Lifecycle config %+v\n", lifecycleConfig)
+
+	return lifecycle.Run(ctx, inventory.Open(lifecycleConfig.InventoryFile), lifecycleConfig)
+}
+
+func runTunnel(ctx context.Context) bool {
+	tunnelConfig := model.MakeCommandLineTunnelConfig()
+
+	log.Printf("Tunnel config %+v\n", tunnelConfig)
+
+	return connect.Tunnel(ctx, tunnelConfig)
 }
 
 func main() {
 	if len(os.Args) < 2 {
-		log.Printf("expected 'generate' or 'connect' subcommands")
+		log.Printf("expected 'generate', 'connect', 'start', 'stop', 'reboot' or 'status' subcommands")
 		os.Exit(1)
 	}
 
+	// Ctrl-C cancels pending AWS calls, a running ssh handles it by itself
+	//
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	success := false
 
 	switch os.Args[1] {
 	case "generate":
-		success = runGenerate()
+		success = runGenerate(ctx)
 	case "connect":
-		success = runConnect()
+		success = runConnect(ctx)
+	case model.StartAction, model.StopAction, model.RebootAction, model.StatusAction:
+		success = runLifecycle(ctx, os.Args[1])
+	case model.TunnelCommand:
+		success = runTunnel(ctx)
 	default:
-		log.Printf("expected 'generate' or 'connect' subcommands")
+		log.Printf("expected 'generate', 'connect', 'start', 'stop', 'reboot' or 'status' subcommands")
+	}
+
+	if ctx.Err() != nil {
+		log.Printf("Interrupted")
 	}
 
 	if !success {
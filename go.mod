@@ -5,6 +5,15 @@ go 1.16
 require (
 	github.com/aws/aws-sdk-go-v2 v1.27.0
 	github.com/aws/aws-sdk-go-v2/config v1.27.16
+	github.com/aws/aws-sdk-go-v2/credentials v1.17.16
 	github.com/aws/aws-sdk-go-v2/service/ec2 v1.162.0
+	github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect v1.23.6
+	github.com/aws/aws-sdk-go-v2/service/organizations v1.27.7
+	github.com/aws/aws-sdk-go-v2/service/route53 v1.40.8
+	github.com/aws/aws-sdk-go-v2/service/ssm v1.50.4
+	github.com/aws/aws-sdk-go-v2/service/sso v1.20.9
+	github.com/aws/aws-sdk-go-v2/service/sts v1.28.10
+	github.com/aws/smithy-go v1.20.2
 	github.com/google/go-cmp v0.5.4 // indirect
+	github.com/gorilla/websocket v1.5.0
 )
@@ -0,0 +1,67 @@
+package connect
+
+import (
+	"aws-bassh/pkg/model"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const sshPort = "22"
+
+// The candidates of the first bastion are probed with a TCP connection to their ssh port, and the
+// first reachable one is used. The next bastions are reached through it, so their fallbacks are
+// left to the proxy command. A single candidate isn't probed, ssh may resolve it by its own config
+// (e.g. a Host alias, a Port or a ProxyCommand) and reports the error itself
+func selectReachableBastion(config model.ConnectConfig, instance *types.Instance) (model.ConnectConfig, error) {
+	if !shouldUseBastion(config, instance) || config.ProbeTimeout <= 0 {
+		return config, nil
+	}
+
+	chain := append([]model.BastionMachine{}, getBastionChain(config)...)
+	candidates := chain[0].Candidates()
+
+	if len(candidates) < 2 {
+		return config, nil
+	}
+
+	for _, candidate := range candidates {
+		if !probeBastion(candidate, config.ProbeTimeout) {
+			continue
+		}
+
+		log.Printf("Using bastion %v", getBastionAddress(candidate))
+
+		chain[0] = candidate
+		return setBastionChain(config, chain), nil
+	}
+
+	log.Printf("No reachable bastion among %v, use --bastion-probe-timeout 0 to skip the check", joinBastionAddresses(candidates))
+	return config, errors.New("no reachable bastion")
+}
+
+func probeBastion(bastion model.BastionMachine, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(bastion.Url, sshPort), timeout)
+
+	if err != nil {
+		log.Printf("Bastion %v is unreachable, %v", getBastionAddress(bastion), err)
+		return false
+	}
+
+	conn.Close()
+	return true
+}
+
+func joinBastionAddresses(bastions []model.BastionMachine) string {
+	addresses := []string{}
+
+	for _, bastion := range bastions {
+		addresses = append(addresses, getBastionAddress(bastion))
+	}
+
+	return strings.Join(addresses, ", ")
+}
@@ -0,0 +1,164 @@
+package connect
+
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/model"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Bastions referenced by instance id or name are resolved to the current address of the bastion,
+// so a bastion replaced behind an ASG is found without generating again
+func resolveBastions(machineSession *lazySession, config model.ConnectConfig, instance *types.Instance) (model.ConnectConfig, error) {
+	if !shouldUseBastion(config, instance) {
+		return config, nil
+	}
+
+	chain := []model.BastionMachine{}
+
+	for i, bastion := range getBastionChain(config) {
+		resolved, err := resolveBastionCandidates(machineSession, config, bastion, i == 0)
+
+		if err != nil {
+			return config, err
+		}
+
+		chain = append(chain, resolved)
+	}
+
+	return setBastionChain(config, chain), nil
+}
+
+// Candidates which can't be resolved are skipped, as long as one of them is resolved
+func resolveBastionCandidates(machineSession *lazySession, config model.ConnectConfig, bastion model.BastionMachine, first bool) (model.BastionMachine, error) {
+	resolved := model.BastionMachine{}
+	var lastErr error
+
+	for _, candidate := range bastion.Candidates() {
+		if candidate.IsReference() {
+			resolvedCandidate, err := resolveBastion(machineSession, config, candidate, first)
+
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			candidate = resolvedCandidate
+		}
+
+		if resolved.IsEmpty() {
+			resolved = candidate
+		} else {
+			resolved.Fallbacks = append(resolved.Fallbacks, candidate)
+		}
+	}
+
+	if resolved.IsEmpty() {
+		return bastion, lastErr
+	}
+
+	return resolved, nil
+}
+
+// The first bastion must have a public IP, the next ones are reached through it by their private IP
+func resolveBastion(machineSession *lazySession, config model.ConnectConfig, bastion model.BastionMachine, first bool) (model.BastionMachine, error) {
+	session, err := machineSession.get()
+
+	if err != nil {
+		return bastion, err
+	}
+
+	instance, err := describeBastionInstance(session, config.Machine.Region, bastion)
+
+	if err != nil {
+		return bastion, err
+	}
+
+	reference := bastion.InstanceId
+
+	if reference == "" {
+		reference = fmt.Sprintf("%v (%v)", bastion.Name, *instance.InstanceId)
+	}
+
+	if instance.State.Name != types.InstanceStateNameRunning {
+		log.Printf("Bastion %v is %v, it must be running to connect through it", reference, instance.State.Name)
+		return bastion, errors.New("bastion is not running")
+	}
+
+	switch {
+	case instance.PublicIpAddress != nil:
+		bastion.Url = *instance.PublicIpAddress
+	case !first && instance.PrivateIpAddress != nil:
+		bastion.Url = *instance.PrivateIpAddress
+	default:
+		log.Printf("Bastion %v has no public IP", reference)
+		return bastion, errors.New("bastion has no public IP")
+	}
+
+	bastion.InstanceId = *instance.InstanceId
+
+	log.Printf("Resolved bastion %v to %v", reference, bastion.Url)
+	return bastion, nil
+}
+
+func describeBastionInstance(session *ec2client.Session, region string, bastion model.BastionMachine) (*types.Instance, error) {
+	if bastion.InstanceId != "" {
+		return session.DescribeInstance(region, bastion.InstanceId)
+	}
+
+	instances, err := describeInstancesByName(session, region, bastion)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(instances) == 0 {
+		log.Printf("Bastion %v not found in region %v", bastion.Name, region)
+		return nil, fmt.Errorf("bastion %v not found", bastion.Name)
+	}
+
+	// Several instances may share the name during a replacement, a running one is preferred
+	//
+	sort.SliceStable(instances, func(i, j int) bool {
+		iRunning := instances[i].State.Name == types.InstanceStateNameRunning
+		jRunning := instances[j].State.Name == types.InstanceStateNameRunning
+
+		if iRunning != jRunning {
+			return iRunning
+		}
+
+		return *instances[i].InstanceId < *instances[j].InstanceId
+	})
+
+	return &instances[0], nil
+}
+
+// The name tags are tried in order, like the machine name is taken from the first name tag found
+func describeInstancesByName(session *ec2client.Session, region string, bastion model.BastionMachine) ([]types.Instance, error) {
+	instances := []types.Instance{}
+
+	for _, nameTag := range bastion.GetNameTags() {
+		reservations, err := session.DescribeInstances(region, []types.Filter{
+			{Name: aws.String("tag:" + nameTag), Values: []string{bastion.Name}},
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, reservation := range reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+
+		if len(instances) > 0 {
+			break
+		}
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,179 @@
+package connect
+
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/model"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/gorilla/websocket"
+)
+
+const tunnelBufferSize = 32 * 1024
+
+// A machine with an instance connect endpoint is connected through it instead of through its bastions,
+// unless it has a public IP. --eice forces the endpoint, --via and --force-bastion force the bastions
+func shouldUseEndpoint(config model.ConnectConfig, instance *types.Instance) bool {
+	if config.Machine.Endpoint == nil || len(config.Via) > 0 {
+		return false
+	}
+
+	if config.UseEndpoint {
+		return true
+	}
+
+	if config.ForceBastion && len(config.Machine.Bastions) > 0 {
+		return false
+	}
+
+	return instance.PublicIpAddress == nil
+}
+
+func validateEndpoint(config model.ConnectConfig, instance *types.Instance) bool {
+	if config.UseEndpoint && config.Machine.Endpoint == nil {
+		log.Printf("Machine %v has no instance connect endpoint, run generate with --instance-connect-endpoints", config.Machine.Id)
+		return false
+	}
+
+	if shouldUseEndpoint(config, instance) && instance.PrivateIpAddress == nil {
+		log.Printf("Machine %v has no private IP, the instance connect endpoint can't reach it", config.Machine.Id)
+		return false
+	}
+
+	return true
+}
+
+func buildEndpointArgs(config model.ConnectConfig, instance *types.Instance) []string {
+	return []string{"-o", "proxycommand " + generateEndpointProxyCommand(config, instance)}
+}
+
+// The tunnel is opened by awsbassh itself, with the session arguments of connect. The % of the
+// arguments is escaped, so only %p is expanded by ssh
+func generateEndpointProxyCommand(config model.ConnectConfig, instance *types.Instance) string {
+	args := []string{
+		"--profile", config.AwsProfile,
+		"--region", config.Machine.Region,
+		"--endpoint-id", config.Machine.Endpoint.Id,
+		"--endpoint-dns", config.Machine.Endpoint.DnsName,
+		"--address", aws.ToString(instance.PrivateIpAddress),
+	}
+
+	if role := getMachineRole(config); role != model.NoRole {
+		args = append(args, "--role-arn", role.RoleArn, "--external-id", role.ExternalId, "--role-session-name", role.SessionName)
+	}
+
+	command := []string{shellQuote(getAwsbasshExec()), model.TunnelCommand}
+
+	for _, arg := range append(args, config.AwsOptions.CommandLineArgs()...) {
+		command = append(command, shellQuote(strings.ReplaceAll(arg, "%", "%%")))
+	}
+
+	return strings.Join(append(command, "--port", "%p"), " ")
+}
+
+func getAwsbasshExec() string {
+	exe, err := os.Executable()
+
+	if err != nil {
+		log.Printf("Error getting the awsbassh executable, %v", err)
+		return os.Args[0]
+	}
+
+	return exe
+}
+
+// Pipes stdin and stdout through a tunnel of the instance connect endpoint, it runs as the ssh proxy command
+func Tunnel(ctx context.Context, config model.TunnelConfig) bool {
+	if config.Endpoint.Id == "" || config.Endpoint.DnsName == "" || config.Address == "" {
+		log.Printf("Missing --endpoint-id, --endpoint-dns or --address")
+		return false
+	}
+
+	session, err := ec2client.InitializeWithRole(ctx, config.AwsProfile, config.AwsOptions, config.AssumeRole)
+
+	if err != nil {
+		return false
+	}
+
+	conn, err := session.OpenEndpointTunnel(config.Region, config.Endpoint, config.Address, config.Port, config.MaxDuration)
+
+	if err != nil {
+		return false
+	}
+
+	defer conn.Close()
+
+	if err := pipeTunnel(ctx, conn, os.Stdin, os.Stdout); err != nil {
+		log.Printf("Tunnel to %v:%v closed, %v", config.Address, config.Port, err)
+		return false
+	}
+
+	return true
+}
+
+// The tunnel ends when ssh closes its side, when the endpoint closes the tunnel, or when interrupted
+func pipeTunnel(ctx context.Context, conn *websocket.Conn, input io.Reader, output io.Writer) error {
+	errs := make(chan error, 2)
+
+	go func() {
+		errs <- copyToTunnel(conn, input)
+	}()
+
+	go func() {
+		errs <- copyFromTunnel(conn, output)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func copyToTunnel(conn *websocket.Conn, input io.Reader) error {
+	buffer := make([]byte, tunnelBufferSize)
+
+	for {
+		n, err := input.Read(buffer)
+
+		if n > 0 {
+			if writeErr := conn.WriteMessage(websocket.BinaryMessage, buffer[:n]); writeErr != nil {
+				return writeErr
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+			return conn.WriteMessage(websocket.CloseMessage, closeMessage)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func copyFromTunnel(conn *websocket.Conn, output io.Writer) error {
+	for {
+		_, data, err := conn.ReadMessage()
+
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := output.Write(data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package connect
+
+import (
+	"aws-bassh/pkg/model"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// With --instance-connect the public key of the ephemeral key is pushed to the machine, and to the bastions
+// which are instances, and ssh uses the ephemeral key for them. Other bastions, e.g. --via hops, keep their keys
+func pushInstanceConnectKeys(machineSession *lazySession, config model.ConnectConfig, instance *types.Instance) (model.ConnectConfig, error) {
+	if !config.InstanceConnect {
+		return config, nil
+	}
+
+	publicKey, err := loadEphemeralKey(config.InstanceConnectKey)
+
+	if err != nil {
+		return config, err
+	}
+
+	session, err := machineSession.get()
+
+	if err != nil {
+		return config, err
+	}
+
+	if err := session.SendSSHPublicKey(config.Machine.Region, config.Machine.Id, getMachineUser(config), publicKey); err != nil {
+		return config, err
+	}
+
+	config.Machine.Keyfile = config.InstanceConnectKey
+
+	if !shouldUseBastion(config, instance) {
+		return config, nil
+	}
+
+	chain := []model.BastionMachine{}
+
+	for _, bastion := range getBastionChain(config) {
+		pushed, err := pushBastionKeys(machineSession, config, bastion, publicKey)
+
+		if err != nil {
+			return config, err
+		}
+
+		chain = append(chain, pushed)
+	}
+
+	return setBastionChain(config, chain), nil
+}
+
+// The key must reach the primary bastion, a fallback which doesn't get it is left with its own key
+func pushBastionKeys(machineSession *lazySession, config model.ConnectConfig, bastion model.BastionMachine, publicKey string) (model.BastionMachine, error) {
+	session, err := machineSession.get()
+
+	if err != nil {
+		return bastion, err
+	}
+
+	candidates := bastion.Candidates()
+
+	for i := range candidates {
+		if candidates[i].InstanceId == "" || candidates[i].User == "" {
+			continue
+		}
+
+		err := session.SendSSHPublicKey(config.Machine.Region, candidates[i].InstanceId, candidates[i].User, publicKey)
+
+		if err != nil && i == 0 {
+			return bastion, err
+		}
+
+		if err == nil {
+			candidates[i].Keyfile = config.InstanceConnectKey
+		}
+	}
+
+	pushed := candidates[0]
+	pushed.Fallbacks = candidates[1:]
+
+	return pushed, nil
+}
+
+// The ephemeral key is generated by ssh-keygen on first use, and reused by the following connections
+func loadEphemeralKey(keyfile string) (string, error) {
+	if keyfile == "" {
+		log.Printf("Missing --instance-connect-key")
+		return "", errors.New("missing instance connect key")
+	}
+
+	if _, err := os.Stat(keyfile); os.IsNotExist(err) {
+		if err := generateEphemeralKey(keyfile); err != nil {
+			return "", err
+		}
+	}
+
+	publicKey, err := os.ReadFile(keyfile + ".pub")
+
+	if err != nil {
+		log.Printf("Error reading the public key of %v, %v", keyfile, err)
+		return "", err
+	}
+
+	return strings.TrimSpace(string(publicKey)), nil
+}
+
+func generateEphemeralKey(keyfile string) error {
+	if err := os.MkdirAll(filepath.Dir(keyfile), 0700); err != nil {
+		log.Printf("Error creating the directory of %v, %v", keyfile, err)
+		return err
+	}
+
+	output, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "awsbassh-instance-connect", "-f", keyfile).CombinedOutput()
+
+	if err != nil {
+		log.Printf("Error generating ssh key %v, %v %s", keyfile, err, output)
+		return err
+	}
+
+	log.Printf("Generated ssh key %v", keyfile)
+	return nil
+}
@@ -0,0 +1,138 @@
+package connect
+
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/model"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Addresses of Elastic IPs are owned by the account, other public IPs are owned by amazon
+const amazonIpOwner = "amazon"
+
+func getMachineAddress(config model.ConnectConfig, instance *types.Instance) (string, error) {
+	strategy := getAddressStrategy(config)
+	viaProxy := shouldUseBastion(config, instance) || shouldUseEndpoint(config, instance)
+	address := findMachineAddress(strategy, instance, config.Machine, viaProxy)
+
+	if address == "" {
+		log.Printf("Machine %v has no %v address, use --address to select another one", config.Machine.Id, describeAddressStrategy(strategy))
+		return "", errors.New("missing machine address")
+	}
+
+	return address, nil
+}
+
+// --address is stronger than the address tag of the machine, --use-public-dns is kept for compatibility
+func getAddressStrategy(config model.ConnectConfig) string {
+	if config.Address != model.AutoAddress {
+		return config.Address
+	}
+
+	if config.UsePublicDns {
+		return model.PublicDnsAddress
+	}
+
+	return config.Machine.Address
+}
+
+// By default a machine is connected by its Route 53 name, otherwise by its public IP, or by its private
+// IP through a bastion or an instance connect endpoint, IPv6 only machines are connected by their IPv6 address.
+// A name of a private zone resolves only in the VPC, so it is the default only through a proxy
+func findMachineAddress(strategy string, instance *types.Instance, machine model.Machine, viaProxy bool) string {
+	switch {
+	case strategy == model.AutoAddress && machine.DnsName != "" && (viaProxy || !machine.PrivateZone):
+		return machine.DnsName
+	case strategy == model.AutoAddress && viaProxy:
+		return firstAddress(instance.PrivateIpAddress, ec2client.FindIpv6Address(instance), instance.PrivateDnsName)
+	case strategy == model.AutoAddress:
+		return firstAddress(instance.PublicIpAddress, instance.PrivateIpAddress, ec2client.FindIpv6Address(instance))
+	case strategy == model.PublicIpAddress:
+		return aws.ToString(instance.PublicIpAddress)
+	case strategy == model.ElasticIpAddress:
+		return findElasticIpAddress(instance)
+	case strategy == model.PublicDnsAddress:
+		return aws.ToString(instance.PublicDnsName)
+	case strategy == model.PrivateIpAddress:
+		return aws.ToString(instance.PrivateIpAddress)
+	case strategy == model.PrivateDnsAddress:
+		return aws.ToString(instance.PrivateDnsName)
+	case strategy == model.Ipv6Address:
+		return aws.ToString(ec2client.FindIpv6Address(instance))
+	case strategy == model.DnsAddress:
+		return machine.DnsName
+	case strings.HasPrefix(strategy, model.EniAddressPrefix):
+		return findEniAddress(instance, strings.TrimPrefix(strategy, model.EniAddressPrefix))
+	default:
+		return ""
+	}
+}
+
+func firstAddress(addresses ...*string) string {
+	for _, address := range addresses {
+		if aws.ToString(address) != "" {
+			return *address
+		}
+	}
+
+	return ""
+}
+
+func findElasticIpAddress(instance *types.Instance) string {
+	for _, networkInterface := range instance.NetworkInterfaces {
+		association := networkInterface.Association
+
+		if association == nil || aws.ToString(association.IpOwnerId) == amazonIpOwner {
+			continue
+		}
+
+		if aws.ToString(association.PublicIp) != "" {
+			return *association.PublicIp
+		}
+	}
+
+	return ""
+}
+
+// The network interface is selected by its id, or by its device index, e.g. 0 is the primary interface
+func findEniAddress(instance *types.Instance, eni string) string {
+	deviceIndex, err := strconv.Atoi(eni)
+
+	for _, networkInterface := range instance.NetworkInterfaces {
+		if err == nil && networkInterface.Attachment != nil && aws.ToInt32(networkInterface.Attachment.DeviceIndex) == int32(deviceIndex) {
+			return aws.ToString(networkInterface.PrivateIpAddress)
+		}
+
+		if aws.ToString(networkInterface.NetworkInterfaceId) == eni {
+			return aws.ToString(networkInterface.PrivateIpAddress)
+		}
+	}
+
+	return ""
+}
+
+func describeAddressStrategy(strategy string) string {
+	if strategy == model.AutoAddress {
+		return "public, private or IPv6"
+	}
+
+	return strategy
+}
+
+func isIpv6Address(address string) bool {
+	return strings.Contains(address, ":")
+}
+
+// sftp takes user@host:path, so IPv6 addresses are bracketed
+func formatUserAddress(user string, address string, sftp bool) string {
+	if sftp && isIpv6Address(address) {
+		return user + "@[" + address + "]"
+	}
+
+	return user + "@" + address
+}
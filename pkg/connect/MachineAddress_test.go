@@ -0,0 +1,82 @@
+package connect
+
+import (
+	"aws-bassh/pkg/model"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func makeAddressTestInstance() *types.Instance {
+	return &types.Instance{
+		PublicIpAddress:  aws.String("1.2.3.4"),
+		PublicDnsName:    aws.String("ec2-1-2-3-4.compute-1.amazonaws.com"),
+		PrivateIpAddress: aws.String("10.0.0.5"),
+		PrivateDnsName:   aws.String("ip-10-0-0-5.ec2.internal"),
+		NetworkInterfaces: []types.InstanceNetworkInterface{
+			{
+				NetworkInterfaceId: aws.String("eni-primary"),
+				PrivateIpAddress:   aws.String("10.0.0.5"),
+				Attachment:         &types.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int32(0)},
+				Association:        &types.InstanceNetworkInterfaceAssociation{IpOwnerId: aws.String("amazon"), PublicIp: aws.String("1.2.3.4")},
+				Ipv6Addresses:      []types.InstanceIpv6Address{{Ipv6Address: aws.String("2001:db8::5")}},
+			},
+			{
+				NetworkInterfaceId: aws.String("eni-secondary"),
+				PrivateIpAddress:   aws.String("10.0.1.5"),
+				Attachment:         &types.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int32(1)},
+				Association:        &types.InstanceNetworkInterfaceAssociation{IpOwnerId: aws.String("123456789012"), PublicIp: aws.String("5.6.7.8")},
+			},
+		},
+	}
+}
+
+func TestFindMachineAddress(t *testing.T) {
+	instance := makeAddressTestInstance()
+	privateOnly := &types.Instance{PrivateIpAddress: aws.String("10.0.0.6")}
+	ipv6Only := &types.Instance{Ipv6Address: aws.String("2001:db8::6")}
+
+	publicName := model.Machine{DnsName: "web1.example.com"}
+	privateName := model.Machine{DnsName: "web1.internal.example.com", PrivateZone: true}
+
+	tests := []struct {
+		name     string
+		strategy string
+		instance *types.Instance
+		machine  model.Machine
+		viaProxy bool
+		expected string
+	}{
+		{"auto public ip", model.AutoAddress, instance, model.NoMachine, false, "1.2.3.4"},
+		{"auto private ip through a proxy", model.AutoAddress, instance, model.NoMachine, true, "10.0.0.5"},
+		{"auto private ip without public ip", model.AutoAddress, privateOnly, model.NoMachine, false, "10.0.0.6"},
+		{"auto ipv6 only", model.AutoAddress, ipv6Only, model.NoMachine, false, "2001:db8::6"},
+		{"auto ipv6 only through a proxy", model.AutoAddress, ipv6Only, model.NoMachine, true, "2001:db8::6"},
+		{"auto public zone name", model.AutoAddress, instance, publicName, false, "web1.example.com"},
+		{"auto private zone name without a proxy", model.AutoAddress, instance, privateName, false, "1.2.3.4"},
+		{"auto private zone name through a proxy", model.AutoAddress, instance, privateName, true, "web1.internal.example.com"},
+		{"public ip", model.PublicIpAddress, instance, publicName, true, "1.2.3.4"},
+		{"elastic ip", model.ElasticIpAddress, instance, model.NoMachine, false, "5.6.7.8"},
+		{"public dns", model.PublicDnsAddress, instance, model.NoMachine, false, "ec2-1-2-3-4.compute-1.amazonaws.com"},
+		{"private ip", model.PrivateIpAddress, instance, publicName, false, "10.0.0.5"},
+		{"private dns", model.PrivateDnsAddress, instance, model.NoMachine, false, "ip-10-0-0-5.ec2.internal"},
+		{"ipv6 of a network interface", model.Ipv6Address, instance, model.NoMachine, false, "2001:db8::5"},
+		{"dns", model.DnsAddress, instance, privateName, false, "web1.internal.example.com"},
+		{"dns missing", model.DnsAddress, instance, model.NoMachine, false, ""},
+		{"eni by device index", model.EniAddressPrefix + "1", instance, model.NoMachine, false, "10.0.1.5"},
+		{"eni by id", model.EniAddressPrefix + "eni-secondary", instance, model.NoMachine, false, "10.0.1.5"},
+		{"eni missing", model.EniAddressPrefix + "2", instance, model.NoMachine, false, ""},
+		{"public ip missing", model.PublicIpAddress, privateOnly, model.NoMachine, false, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address := findMachineAddress(test.strategy, test.instance, test.machine, test.viaProxy)
+
+			if address != test.expected {
+				t.Errorf("Expected address %q, got %q", test.expected, address)
+			}
+		})
+	}
+}
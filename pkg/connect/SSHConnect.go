@@ -2,17 +2,56 @@ package connect
 
 import (
 	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/inventory"
 	"aws-bassh/pkg/model"
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"log"
+	"math"
 	"os"
 	"os/exec"
 	"strings"
 )
 
-func SSH(config model.ConnectConfig) bool {
-	instance, err := ec2client.DescribeInstance(config.Machine.Id)
+func SSH(ctx context.Context, inventory *inventory.Inventory, config model.ConnectConfig) bool {
+	if !model.IsValidAddress(config.Address) {
+		return false
+	}
+
+	machineSession := newLazySession(ctx, config)
+
+	instance, err := describeMachineInstance(machineSession, inventory, config)
+
+	if err != nil {
+		return false
+	}
+
+	if config.StartIfStopped && isStopped(instance) {
+		instance, err = startStoppedInstance(machineSession, inventory, config, instance)
+
+		if err != nil {
+			return false
+		}
+	}
+
+	if config.Ssm {
+		return connectWithSsm(machineSession, config, instance)
+	}
+
+	config, err = resolveBastions(machineSession, config, instance)
+
+	if err != nil {
+		return false
+	}
+
+	config, err = selectReachableBastion(config, instance)
+
+	if err != nil {
+		return false
+	}
+
+	config, err = pushInstanceConnectKeys(machineSession, config, instance)
 
 	if err != nil {
 		return false
@@ -21,6 +60,70 @@ func SSH(config model.ConnectConfig) bool {
 	return validateAndConnectToInstance(config, instance)
 }
 
+// The cached instance saves the AWS calls, the session is initialized only for a live lookup.
+// Only running instances are taken from the cache, other states are likely to change soon.
+func describeMachineInstance(machineSession *lazySession, inventory *inventory.Inventory, config model.ConnectConfig) (*types.Instance, error) {
+	if !config.Refresh {
+		instance, found := inventory.Lookup(config.Machine.Region, config.Machine.Id, config.InventoryTtl)
+
+		if found && instance.State.Name == types.InstanceStateNameRunning {
+			return instance, nil
+		}
+	}
+
+	session, err := machineSession.get()
+
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err := session.DescribeInstance(config.Machine.Region, config.Machine.Id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	inventory.AddInstance(config.Machine.Region, *instance)
+	inventory.Save()
+
+	return instance, nil
+}
+
+// The session of the machine account, created once by SSH and passed to the steps which call AWS. It is
+// initialized on first use, a cached running instance may need no AWS call at all
+type lazySession struct {
+	ctx     context.Context
+	config  model.ConnectConfig
+	session *ec2client.Session
+}
+
+func newLazySession(ctx context.Context, config model.ConnectConfig) *lazySession {
+	return &lazySession{ctx: ctx, config: config}
+}
+
+func (machineSession *lazySession) get() (*ec2client.Session, error) {
+	if machineSession.session != nil {
+		return machineSession.session, nil
+	}
+
+	session, err := ec2client.InitializeWithRole(machineSession.ctx, machineSession.config.AwsProfile, machineSession.config.AwsOptions, getMachineRole(machineSession.config))
+
+	if err != nil {
+		return nil, err
+	}
+
+	machineSession.session = session
+	return session, nil
+}
+
+func getMachineRole(config model.ConnectConfig) model.AssumeRole {
+	if config.AssumeRole != model.NoRole {
+		return config.AssumeRole
+	}
+
+	return config.Machine.Role
+}
+
 func validateAndConnectToInstance(config model.ConnectConfig, instance *types.Instance) bool {
 	if !validateBeforeConnect(config, instance) {
 		return false
@@ -34,11 +137,17 @@ func validateBeforeConnect(config model.ConnectConfig, instance *types.Instance)
 		return false
 	}
 
-	if !validateKeyfile(config.Machine.Keyfile) {
+	if config.Machine.Keyfile == "" {
+		log.Printf("Machine %v has no ssh key, connecting with the ssh defaults, see --instance-connect", config.Machine.Id)
+	} else if !validateKeyfile(config.Machine.Keyfile) {
 		return false
 	}
 
-	if shouldUseBastion(config, instance) && !validateKeyfile(config.Machine.Bastion.Keyfile) {
+	if shouldUseBastion(config, instance) && !validateBastionKeyfiles(getBastionChain(config)) {
+		return false
+	}
+
+	if !validateEndpoint(config, instance) {
 		return false
 	}
 
@@ -51,9 +160,25 @@ func checkMachineState(instance *types.Instance) bool {
 	}
 
 	log.Printf("Invalid machine state: %v", instance.State.Name)
+
+	if isStopped(instance) {
+		log.Printf("Use --start-if-stopped to start the machine")
+	}
+
 	return false
 }
 
+// Bastions without a key use the ssh defaults, e.g. hops given by --via
+func validateBastionKeyfiles(chain []model.BastionMachine) bool {
+	for _, bastion := range chain {
+		if bastion.Keyfile != "" && !validateKeyfile(bastion.Keyfile) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func validateKeyfile(keyfile string) bool {
 	if _, err := os.Stat(keyfile); os.IsNotExist(err) {
 		log.Printf("")
@@ -70,8 +195,14 @@ func validateKeyfile(keyfile string) bool {
 }
 
 func connectToInstance(config model.ConnectConfig, instance *types.Instance) bool {
+	address, err := getMachineAddress(config, instance)
+
+	if err != nil {
+		return false
+	}
+
 	exe := getExec(config)
-	args := buildArgs(config, instance)
+	args := buildArgs(config, instance, address)
 
 	logCommand(exe, args)
 	spawn(exe, args)
@@ -79,14 +210,18 @@ func connectToInstance(config model.ConnectConfig, instance *types.Instance) boo
 	return true
 }
 
-func buildArgs(config model.ConnectConfig, instance *types.Instance) []string {
+func buildArgs(config model.ConnectConfig, instance *types.Instance, address string) []string {
 	args := buildInitalArgs(config)
 
 	if shouldUseBastion(config, instance) {
-		args = append(args, buildBastionArgs(config, instance)...)
+		args = append(args, buildBastionArgs(config, address)...)
 	}
 
-	args = append(args, buildUserAddressArg(config, instance))
+	if shouldUseEndpoint(config, instance) {
+		args = append(args, buildEndpointArgs(config, instance)...)
+	}
+
+	args = append(args, formatUserAddress(getMachineUser(config), address, config.Sftp))
 	args = append(args, buildCommandsArg(config)...)
 
 	return args
@@ -95,7 +230,12 @@ func buildArgs(config model.ConnectConfig, instance *types.Instance) []string {
 func buildInitalArgs(config model.ConnectConfig) []string {
 	args := []string{}
 
-	args = append(args, "-i", config.Machine.Keyfile)
+	// Machines without a key pair are connected with the ssh defaults, e.g. the agent
+	//
+	if config.Machine.Keyfile != "" {
+		args = append(args, "-i", config.Machine.Keyfile)
+	}
+
 	args = append(args, config.ExtraSSHParams...)
 
 	if len(config.SSHCommands) > 0 && !config.Sftp {
@@ -105,40 +245,35 @@ func buildInitalArgs(config model.ConnectConfig) []string {
 	return args
 }
 
-func shouldUseBastion(config model.ConnectConfig, instance *types.Instance) bool {
-	if config.ForceBastion && config.Machine.Bastion.Url != "" {
-		return true
+// --via overrides the bastions chain of the machine
+func getBastionChain(config model.ConnectConfig) []model.BastionMachine {
+	if len(config.Via) > 0 {
+		return config.Via
 	}
 
-	if instance.PublicIpAddress != nil {
-		return false
-	}
+	return config.Machine.Bastions
+}
 
-	if config.Machine.Bastion.Url != "" {
-		return true
+func setBastionChain(config model.ConnectConfig, chain []model.BastionMachine) model.ConnectConfig {
+	if len(config.Via) > 0 {
+		config.Via = chain
+	} else {
+		config.Machine.Bastions = chain
 	}
 
-	return false
+	return config
 }
 
-func getMachineAddress(config model.ConnectConfig, instance *types.Instance) *string {
-	if config.UsePublicDns {
-		return instance.PublicDnsName
-	} else {
-		if instance.PublicIpAddress != nil {
-			return instance.PublicIpAddress
-		} else {
-			return instance.PrivateIpAddress
-		}
+func shouldUseBastion(config model.ConnectConfig, instance *types.Instance) bool {
+	if len(getBastionChain(config)) == 0 || shouldUseEndpoint(config, instance) {
+		return false
 	}
-}
 
-func buildUserAddressArg(config model.ConnectConfig, instance *types.Instance) string {
-	if shouldUseBastion(config, instance) {
-		return getMachineUser(config) + "@" + *instance.PrivateIpAddress
-	} else {
-		return getMachineUser(config) + "@" + *getMachineAddress(config, instance)
+	if config.ForceBastion || len(config.Via) > 0 {
+		return true
 	}
+
+	return instance.PublicIpAddress == nil
 }
 
 func getMachineUser(config model.ConnectConfig) string {
@@ -149,23 +284,79 @@ func getMachineUser(config model.ConnectConfig) string {
 	}
 }
 
-func buildBastionArgs(config model.ConnectConfig, instance *types.Instance) []string {
+func buildBastionArgs(config model.ConnectConfig, address string) []string {
 	bastionArgs := []string{}
 
 	bastionArgs = append(bastionArgs, "-o")
-	bastionArgs = append(bastionArgs, generateBastionProxyCommand(config))
+	bastionArgs = append(bastionArgs, "proxycommand "+generateBastionProxyCommand(config, getBastionChain(config), []string{address}))
 
 	return bastionArgs
 }
 
-func generateBastionProxyCommand(config model.ConnectConfig) string {
-	return fmt.Sprintf("proxycommand ssh %s -W %s -f -i %s %s@%s",
-		strings.Join(config.ExtraSSHParams, " "),
-		"%h:%p",
-		config.Machine.Bastion.Keyfile,
-		config.Machine.Bastion.User,
-		config.Machine.Bastion.Url,
-	)
+// Like ProxyJump, the last bastion is reached through the previous ones. Their proxy command is
+// nested with its % escaped, so its %h:%p are expanded by the ssh of the next bastion.
+// The fallbacks of a bastion are tried by the shell when the connection to the bastion fails.
+// The targets are the next hop candidates, their IPv6 addresses are forwarded as [%h]:%p
+func generateBastionProxyCommand(config model.ConnectConfig, chain []model.BastionMachine, targets []string) string {
+	bastion := chain[len(chain)-1]
+	candidates := bastion.Candidates()
+	commands := []string{}
+
+	for i, candidate := range candidates {
+		command := generateBastionHopCommand(config, chain[:len(chain)-1], candidate, len(candidates) > 1, targets)
+
+		if i > 0 {
+			message := fmt.Sprintf("Bastion %v is unreachable, trying %v", getBastionAddress(candidates[i-1]), getBastionAddress(candidate))
+			command = fmt.Sprintf("{ echo %v >&2; %v; }", shellQuote(message), command)
+		}
+
+		commands = append(commands, command)
+	}
+
+	return strings.Join(commands, " || ")
+}
+
+func generateBastionHopCommand(config model.ConnectConfig, previousChain []model.BastionMachine, bastion model.BastionMachine, hasFallbacks bool, targets []string) string {
+	args := []string{"ssh", strings.Join(config.ExtraSSHParams, " ")}
+
+	if len(previousChain) > 0 {
+		previousCommand := generateBastionProxyCommand(config, previousChain, []string{bastion.Url})
+		args = append(args, "-o", shellQuote("proxycommand "+strings.ReplaceAll(previousCommand, "%", "%%")))
+	}
+
+	if hasFallbacks && config.ProbeTimeout > 0 {
+		args = append(args, "-o", fmt.Sprintf("ConnectTimeout=%v", int(math.Ceil(config.ProbeTimeout.Seconds()))))
+	}
+
+	args = append(args, "-W", getForwardSpec(targets), "-f")
+
+	if bastion.Keyfile != "" {
+		args = append(args, "-i", bastion.Keyfile)
+	}
+
+	return strings.Join(append(args, getBastionAddress(bastion)), " ")
+}
+
+func getForwardSpec(targets []string) string {
+	for _, target := range targets {
+		if isIpv6Address(target) {
+			return "[%h]:%p"
+		}
+	}
+
+	return "%h:%p"
+}
+
+func getBastionAddress(bastion model.BastionMachine) string {
+	if bastion.User == "" {
+		return bastion.Url
+	}
+
+	return bastion.User + "@" + bastion.Url
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "'\\''") + "'"
 }
 
 func buildCommandsArg(config model.ConnectConfig) []string {
@@ -193,7 +384,13 @@ func logCommand(exe string, args []string) {
 }
 
 func spawn(exe string, args []string) {
+	spawnWithEnv(exe, args, nil)
+}
+
+// A nil env inherits the environment of awsbassh
+func spawnWithEnv(exe string, args []string, env []string) {
 	cmd := exec.Command(exe, args...)
+	cmd.Env = env
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -0,0 +1,175 @@
+package connect
+
+import (
+	"aws-bassh/pkg/model"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestGenerateBastionProxyCommand(t *testing.T) {
+	jump := model.BastionMachine{Url: "jump.example.com", User: "alice"}
+	jumpFallback := model.BastionMachine{Url: "jump2.example.com", User: "alice"}
+	bastion := model.BastionMachine{Url: "1.2.3.9", User: "ubuntu", Keyfile: "keys/vpc.pem"}
+	bastionFallback := model.BastionMachine{Url: "1.2.3.10", User: "ubuntu", Keyfile: "keys/vpc.pem"}
+
+	withFallback := func(bastion model.BastionMachine, fallback model.BastionMachine) model.BastionMachine {
+		bastion.Fallbacks = []model.BastionMachine{fallback}
+		return bastion
+	}
+
+	tests := []struct {
+		name     string
+		chain    []model.BastionMachine
+		target   string
+		expected string
+	}{
+		{
+			name:     "single bastion",
+			chain:    []model.BastionMachine{bastion},
+			target:   "10.0.0.5",
+			expected: "ssh -q -W %h:%p -f -i keys/vpc.pem ubuntu@1.2.3.9",
+		},
+		{
+			name:     "ipv6 target",
+			chain:    []model.BastionMachine{bastion},
+			target:   "2001:db8::5",
+			expected: "ssh -q -W [%h]:%p -f -i keys/vpc.pem ubuntu@1.2.3.9",
+		},
+		{
+			name:     "nested chain escapes the inner %",
+			chain:    []model.BastionMachine{jump, bastion},
+			target:   "10.0.0.5",
+			expected: "ssh -q -o 'proxycommand ssh -q -W %%h:%%p -f alice@jump.example.com' -W %h:%p -f -i keys/vpc.pem ubuntu@1.2.3.9",
+		},
+		{
+			name:   "fallbacks are tried with ||",
+			chain:  []model.BastionMachine{withFallback(bastion, bastionFallback)},
+			target: "10.0.0.5",
+			expected: "ssh -q -o ConnectTimeout=3 -W %h:%p -f -i keys/vpc.pem ubuntu@1.2.3.9 || " +
+				"{ echo 'Bastion ubuntu@1.2.3.9 is unreachable, trying ubuntu@1.2.3.10' >&2; " +
+				"ssh -q -o ConnectTimeout=3 -W %h:%p -f -i keys/vpc.pem ubuntu@1.2.3.10; }",
+		},
+		{
+			name:   "nested fallbacks are quoted and escaped",
+			chain:  []model.BastionMachine{withFallback(jump, jumpFallback), bastion},
+			target: "10.0.0.5",
+			expected: "ssh -q -o 'proxycommand ssh -q -o ConnectTimeout=3 -W %%h:%%p -f alice@jump.example.com || " +
+				"{ echo '\\''Bastion alice@jump.example.com is unreachable, trying alice@jump2.example.com'\\'' >&2; " +
+				"ssh -q -o ConnectTimeout=3 -W %%h:%%p -f alice@jump2.example.com; }' -W %h:%p -f -i keys/vpc.pem ubuntu@1.2.3.9",
+		},
+		{
+			name:   "every candidate of the last hop goes through the previous hops",
+			chain:  []model.BastionMachine{jump, withFallback(bastion, bastionFallback)},
+			target: "10.0.0.5",
+			expected: "ssh -q -o 'proxycommand ssh -q -W %%h:%%p -f alice@jump.example.com' -o ConnectTimeout=3 -W %h:%p -f -i keys/vpc.pem ubuntu@1.2.3.9 || " +
+				"{ echo 'Bastion ubuntu@1.2.3.9 is unreachable, trying ubuntu@1.2.3.10' >&2; " +
+				"ssh -q -o 'proxycommand ssh -q -W %%h:%%p -f alice@jump.example.com' -o ConnectTimeout=3 -W %h:%p -f -i keys/vpc.pem ubuntu@1.2.3.10; }",
+		},
+	}
+
+	config := model.ConnectConfig{
+		ExtraSSHParams: []string{"-q"},
+		ProbeTimeout:   2500 * time.Millisecond,
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command := generateBastionProxyCommand(config, test.chain, []string{test.target})
+
+			if command != test.expected {
+				t.Errorf("Expected proxy command\n%v\ngot\n%v", test.expected, command)
+			}
+		})
+	}
+}
+
+func TestBuildArgsThroughBastion(t *testing.T) {
+	config := model.ConnectConfig{
+		Machine: model.Machine{
+			User:     "ec2-user",
+			Keyfile:  "keys/main.pem",
+			Bastions: []model.BastionMachine{{Url: "1.2.3.9", User: "ubuntu", Keyfile: "keys/vpc.pem"}},
+		},
+		ExtraSSHParams: []string{"-q"},
+	}
+
+	instance := makeAddressTestInstance()
+	instance.PublicIpAddress = nil
+
+	expected := []string{"-i", "keys/main.pem", "-q", "-o", "proxycommand ssh -q -W %h:%p -f -i keys/vpc.pem ubuntu@1.2.3.9", "ec2-user@10.0.0.5"}
+	args := buildArgs(config, instance, "10.0.0.5")
+
+	if len(args) != len(expected) {
+		t.Fatalf("Expected args %q, got %q", expected, args)
+	}
+
+	for i := range expected {
+		if args[i] != expected[i] {
+			t.Fatalf("Expected args %q, got %q", expected, args)
+		}
+	}
+}
+
+// A bastion referenced by name is resolved with the fake EC2 backend, its fallback by instance id
+func TestResolvedBastionProxyCommand(t *testing.T) {
+	running := &types.InstanceState{Name: types.InstanceStateNameRunning}
+	fixture := map[string]interface{}{
+		"DefaultRegion": "us-east-1",
+		"Regions": map[string][]types.Instance{
+			"us-east-1": {
+				{InstanceId: aws.String("i-jump"), State: running, PublicIpAddress: aws.String("1.2.3.9"),
+					Tags: []types.Tag{{Key: aws.String("Hostname"), Value: aws.String("jump")}}},
+				{InstanceId: aws.String("i-jump2"), State: running, PublicIpAddress: aws.String("1.2.3.10")},
+			},
+		},
+	}
+
+	jsonBytes, err := json.Marshal(fixture)
+
+	if err != nil {
+		t.Fatalf("Error marshalling fixture %v", err)
+	}
+
+	fixtureFile := filepath.Join(t.TempDir(), "fixture.json")
+
+	if err := os.WriteFile(fixtureFile, jsonBytes, 0600); err != nil {
+		t.Fatalf("Error writing fixture %v", err)
+	}
+
+	config := model.ConnectConfig{
+		Machine: model.Machine{
+			Id:     "i-db",
+			Region: "us-east-1",
+			Bastions: []model.BastionMachine{{
+				Name:      "jump",
+				NameTags:  []string{"Hostname"},
+				User:      "ubuntu",
+				Fallbacks: []model.BastionMachine{{InstanceId: "i-jump2", User: "ubuntu"}},
+			}},
+		},
+		AwsOptions:     model.AwsOptions{Ec2Fixture: fixtureFile},
+		ExtraSSHParams: []string{"-q"},
+	}
+
+	instance := &types.Instance{InstanceId: aws.String("i-db"), State: running, PrivateIpAddress: aws.String("10.0.0.5")}
+	config, err = resolveBastions(newLazySession(context.Background(), config), config, instance)
+
+	if err != nil {
+		t.Fatalf("Error resolving bastions %v", err)
+	}
+
+	expected := "ssh -q -W %h:%p -f ubuntu@1.2.3.9 || " +
+		"{ echo 'Bastion ubuntu@1.2.3.9 is unreachable, trying ubuntu@1.2.3.10' >&2; ssh -q -W %h:%p -f ubuntu@1.2.3.10; }"
+	command := generateBastionProxyCommand(config, getBastionChain(config), []string{"10.0.0.5"})
+
+	if command != expected {
+		t.Errorf("Expected proxy command\n%v\ngot\n%v", expected, command)
+	}
+}
@@ -0,0 +1,184 @@
+package connect
+
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/model"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const (
+	sessionManagerPlugin       = "session-manager-plugin"
+	interactiveCommandDocument = "AWS-StartInteractiveCommand"
+)
+
+// SSM sessions need neither keys nor bastions, the instance is reached by its SSM agent. The session
+// user is the Run As user of Session Manager, ssm-user by default
+func connectWithSsm(machineSession *lazySession, config model.ConnectConfig, instance *types.Instance) bool {
+	if !checkMachineState(instance) {
+		return false
+	}
+
+	if config.Sftp {
+		log.Printf("sftp isn't supported with SSM, use --ssm=false")
+		return false
+	}
+
+	plugin, err := exec.LookPath(sessionManagerPlugin)
+
+	if err != nil {
+		log.Printf("The %v is missing, see https://docs.aws.amazon.com/systems-manager/latest/userguide/session-manager-working-with-install-plugin.html", sessionManagerPlugin)
+		return false
+	}
+
+	session, err := machineSession.get()
+
+	if err != nil {
+		return false
+	}
+
+	if !checkSsmManaged(session, config) {
+		return false
+	}
+
+	input := buildStartSessionInput(config)
+	output, err := session.StartSsmSession(config.Machine.Region, input)
+
+	if err != nil {
+		return false
+	}
+
+	env, profile, err := buildPluginCredentials(session, config)
+
+	if err != nil {
+		session.TerminateSsmSession(config.Machine.Region, aws.ToString(output.SessionId))
+		return false
+	}
+
+	args, err := buildPluginArgs(session, config, profile, input, output)
+
+	if err != nil {
+		session.TerminateSsmSession(config.Machine.Region, aws.ToString(output.SessionId))
+		return false
+	}
+
+	log.Printf("Connecting machine %v with SSM session %v", config.Machine.Id, aws.ToString(output.SessionId))
+	spawnWithEnv(plugin, args, env)
+
+	return true
+}
+
+func checkSsmManaged(session *ec2client.Session, config model.ConnectConfig) bool {
+	information, err := session.DescribeSsmInstance(config.Machine.Region, config.Machine.Id)
+
+	if err != nil {
+		return false
+	}
+
+	if information == nil {
+		log.Printf("Machine %v is not managed by SSM, check that its SSM agent is running and that its instance profile allows Systems Manager, e.g. AmazonSSMManagedInstanceCore", config.Machine.Id)
+		return false
+	}
+
+	if information.PingStatus != ssmtypes.PingStatusOnline {
+		log.Printf("The SSM agent of machine %v is %v, last ping at %v", config.Machine.Id, information.PingStatus, aws.ToTime(information.LastPingDateTime).Local())
+		return false
+	}
+
+	return true
+}
+
+// --ssh-commands run by the interactive command document, otherwise the session is a shell
+func buildStartSessionInput(config model.ConnectConfig) *ssm.StartSessionInput {
+	input := &ssm.StartSessionInput{Target: aws.String(config.Machine.Id)}
+	command := strings.TrimSpace(strings.Join(config.SSHCommands, " "))
+
+	if command != "" {
+		input.DocumentName = aws.String(interactiveCommandDocument)
+		input.Parameters = map[string][]string{"command": {command}}
+	}
+
+	return input
+}
+
+// The plugin terminates and resumes the session with its own credentials, so with an assumed role it gets
+// the role credentials in its environment and no profile. Otherwise it inherits the environment and the profile
+func buildPluginCredentials(session *ec2client.Session, config model.ConnectConfig) ([]string, string, error) {
+	if getMachineRole(config) == model.NoRole {
+		return nil, config.AwsProfile, nil
+	}
+
+	creds, err := session.RoleCredentials()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	env := []string{}
+
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, "AWS_PROFILE=") && !strings.HasPrefix(variable, "AWS_DEFAULT_PROFILE=") {
+			env = append(env, variable)
+		}
+	}
+
+	env = append(env,
+		"AWS_ACCESS_KEY_ID="+creds.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey,
+		"AWS_SESSION_TOKEN="+creds.SessionToken,
+	)
+
+	return env, "", nil
+}
+
+// Like the AWS CLI, the plugin gets the session, the region, the operation, the profile, the request
+// and the SSM endpoint, which it uses to terminate the session
+func buildPluginArgs(session *ec2client.Session, config model.ConnectConfig, profile string, input *ssm.StartSessionInput, output *ssm.StartSessionOutput) ([]string, error) {
+	if output.StreamUrl == nil || output.TokenValue == nil {
+		log.Printf("SSM session %v has no stream url", aws.ToString(output.SessionId))
+		return nil, errors.New("missing SSM stream url")
+	}
+
+	sessionJson, err := json.Marshal(map[string]string{
+		"SessionId":  aws.ToString(output.SessionId),
+		"StreamUrl":  aws.ToString(output.StreamUrl),
+		"TokenValue": aws.ToString(output.TokenValue),
+	})
+
+	if err != nil {
+		log.Printf("Error serializing SSM session %v, %v", aws.ToString(output.SessionId), err)
+		return nil, err
+	}
+
+	request := map[string]interface{}{"Target": aws.ToString(input.Target)}
+
+	if input.DocumentName != nil {
+		request["DocumentName"] = *input.DocumentName
+		request["Parameters"] = input.Parameters
+	}
+
+	requestJson, err := json.Marshal(request)
+
+	if err != nil {
+		log.Printf("Error serializing SSM session request %v, %v", request, err)
+		return nil, err
+	}
+
+	return []string{
+		string(sessionJson),
+		config.Machine.Region,
+		"StartSession",
+		profile,
+		string(requestJson),
+		session.SsmEndpoint(config.Machine.Region),
+	}, nil
+}
@@ -0,0 +1,42 @@
+package connect
+
+import (
+	"aws-bassh/pkg/inventory"
+	"aws-bassh/pkg/model"
+	"aws-bassh/pkg/prompt"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func isStopped(instance *types.Instance) bool {
+	return instance.State.Name == types.InstanceStateNameStopped || instance.State.Name == types.InstanceStateNameStopping
+}
+
+func startStoppedInstance(machineSession *lazySession, inventory *inventory.Inventory, config model.ConnectConfig, instance *types.Instance) (*types.Instance, error) {
+	question := fmt.Sprintf("Machine %v (%v) is %v, start it?", config.Machine.Name, config.Machine.Id, instance.State.Name)
+
+	if !config.Yes && !prompt.Confirm(question) {
+		log.Printf("Not starting machine %v", config.Machine.Id)
+		return nil, errors.New("start not confirmed")
+	}
+
+	session, err := machineSession.get()
+
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err = session.StartAndWait(config.Machine.Region, instance, config.StartTimeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	inventory.AddInstance(config.Machine.Region, *instance)
+	inventory.Save()
+
+	return instance, nil
+}
@@ -0,0 +1,107 @@
+package ec2client
+
+import (
+	"aws-bassh/pkg/model"
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Initializes the profile session, and assumes the role if there is one
+func InitializeWithRole(ctx context.Context, awsProfile string, options model.AwsOptions, role model.AssumeRole) (*Session, error) {
+	session, err := Initialize(ctx, awsProfile, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if role == model.NoRole {
+		return session, nil
+	}
+
+	return session.AssumeRole(role)
+}
+
+func (session *Session) AssumeRole(role model.AssumeRole) (*Session, error) {
+	roleArn, err := arn.Parse(role.RoleArn)
+
+	if err != nil {
+		log.Printf("Invalid role arn %v, %v", role.RoleArn, err)
+		return nil, err
+	}
+
+	if session.offline {
+		return session.assumeOfflineRole(role, roleArn.AccountID), nil
+	}
+
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(session.awsConfig), role.RoleArn, func(options *stscreds.AssumeRoleOptions) {
+		options.RoleSessionName = role.SessionName
+
+		if role.ExternalId != "" {
+			options.ExternalID = aws.String(role.ExternalId)
+		}
+	})
+
+	awsConfig := session.awsConfig.Copy()
+	awsConfig.Credentials = aws.NewCredentialsCache(provider)
+
+	creds, err := session.retrieveCredentials(awsConfig)
+
+	if err != nil && session.loginOnExpiredCredentials(err) {
+		creds, err = session.retrieveCredentials(awsConfig)
+	}
+
+	if err != nil {
+		log.Printf("Unable to assume role %v, %v", role.RoleArn, err)
+		return nil, err
+	}
+
+	log.Printf("Role assumed, role: %v, account: %v, access key: %v\n", role.RoleArn, roleArn.AccountID, creds.AccessKeyID)
+
+	assumedSession := newSession(session.ctx, session.Profile, session.options, awsConfig, session.newEc2API)
+	assumedSession.AccountId = roleArn.AccountID
+	assumedSession.Role = role
+
+	return assumedSession, nil
+}
+
+func (session *Session) retrieveCredentials(awsConfig aws.Config) (aws.Credentials, error) {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	return awsConfig.Credentials.Retrieve(ctx)
+}
+
+// The credentials of the assumed role, for the tools which can't assume it themselves, e.g. session-manager-plugin
+func (session *Session) RoleCredentials() (aws.Credentials, error) {
+	if session.offline {
+		return aws.Credentials{AccessKeyID: "FAKEACCESSKEYID", SecretAccessKey: "fake", SessionToken: "fake", Source: "fake"}, nil
+	}
+
+	creds, err := session.retrieveCredentials(session.awsConfig)
+
+	if err != nil && session.loginOnExpiredCredentials(err) {
+		creds, err = session.retrieveCredentials(session.awsConfig)
+	}
+
+	if err != nil {
+		log.Printf("Unable to get the credentials of role %v, %v", session.Role.RoleArn, err)
+		return aws.Credentials{}, err
+	}
+
+	return creds, nil
+}
+
+// The fake backend has no STS, the assumed session shares the same fixture
+func (session *Session) assumeOfflineRole(role model.AssumeRole, accountId string) *Session {
+	assumedSession := newSession(session.ctx, session.Profile, session.options, session.awsConfig, session.newEc2API)
+	assumedSession.AccountId = accountId
+	assumedSession.Role = role
+	assumedSession.offline = true
+
+	return assumedSession
+}
@@ -0,0 +1,144 @@
+package ec2client
+
+import (
+	"aws-bassh/pkg/model"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	ssotypes "github.com/aws/aws-sdk-go-v2/service/sso/types"
+	"github.com/aws/smithy-go"
+)
+
+type credentialsProblem int
+
+const (
+	noCredentialsProblem credentialsProblem = iota
+	expiredSsoSession
+	expiredSessionCredentials
+)
+
+var (
+	ssoLoginMutex sync.Mutex
+)
+
+func getCredentialsProblem(err error) credentialsProblem {
+	var invalidTokenErr *ssocreds.InvalidTokenError
+	var unauthorizedErr *ssotypes.UnauthorizedException
+	var apiErr smithy.APIError
+
+	if errors.As(err, &invalidTokenErr) || errors.As(err, &unauthorizedErr) {
+		return expiredSsoSession
+	}
+
+	// Returned by the SSO token provider of profiles with sso-session configuration
+	//
+	if strings.Contains(err.Error(), "SSO token") {
+		return expiredSsoSession
+	}
+
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ExpiredToken", "ExpiredTokenException", "RequestExpired":
+			return expiredSessionCredentials
+		}
+	}
+
+	return noCredentialsProblem
+}
+
+func reportCredentialsProblem(awsProfile string, err error) credentialsProblem {
+	problem := getCredentialsProblem(err)
+
+	switch problem {
+	case expiredSsoSession:
+		log.Printf("")
+		log.Printf("The SSO session of profile %v has expired", getProfileName(awsProfile))
+		log.Printf("Please run 'aws sso login --profile %v' and try again, or pass --sso-login", getProfileName(awsProfile))
+		log.Printf("")
+	case expiredSessionCredentials:
+		log.Printf("")
+		log.Printf("The session credentials of profile %v have expired", getProfileName(awsProfile))
+		log.Printf("Please refresh them (e.g. aws sts get-session-token, or your credentials process) and try again")
+		log.Printf("")
+	}
+
+	return problem
+}
+
+func getProfileName(awsProfile string) string {
+	if awsProfile != "" {
+		return awsProfile
+	}
+
+	if profile := os.Getenv("AWS_PROFILE"); profile != "" {
+		return profile
+	}
+
+	return "default"
+}
+
+// Several profiles may share the same SSO session, logins are serialized and
+// the credentials are checked again before each login
+func ssoLogin(ctx context.Context, awsProfile string, stillExpired func() bool) bool {
+	ssoLoginMutex.Lock()
+	defer ssoLoginMutex.Unlock()
+
+	if !stillExpired() {
+		return true
+	}
+
+	log.Printf("Running 'aws sso login --profile %v'", getProfileName(awsProfile))
+
+	cmd := exec.CommandContext(ctx, "aws", "sso", "login", "--profile", getProfileName(awsProfile))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		log.Printf("SSO login of profile %v failed, %v", getProfileName(awsProfile), err)
+		return false
+	}
+
+	return true
+}
+
+// Returns true if the failed call should be retried, after a successful SSO login
+func (session *Session) loginOnExpiredCredentials(err error) bool {
+	if reportCredentialsProblem(session.Profile, err) != expiredSsoSession {
+		return false
+	}
+
+	if !session.options.SsoLogin {
+		return false
+	}
+
+	return ssoLogin(session.ctx, session.Profile, func() bool {
+		ctx, cancel := session.callContext()
+		defer cancel()
+
+		_, err := session.awsConfig.Credentials.Retrieve(ctx)
+		return err != nil
+	})
+}
+
+// Returns true if the initialization should be retried, after a successful SSO login
+func loginOnExpiredConfig(ctx context.Context, awsProfile string, options model.AwsOptions, err error) bool {
+	if reportCredentialsProblem(awsProfile, err) != expiredSsoSession {
+		return false
+	}
+
+	if !options.SsoLogin {
+		return false
+	}
+
+	return ssoLogin(ctx, awsProfile, func() bool {
+		_, err := initializeAwsConfig(ctx, awsProfile, options)
+		return err != nil
+	})
+}
@@ -1,6 +1,7 @@
 package ec2client
 
 import (
+	"aws-bassh/pkg/model"
 	"context"
 	"errors"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -9,16 +10,31 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"log"
 	"os"
+	"sync"
+	"time"
 )
 
-var (
-	ec2Client *ec2.Client
-)
+type Session struct {
+	Profile   string
+	AccountId string
+	Role      model.AssumeRole
+	ctx       context.Context
+	options   model.AwsOptions
+	awsConfig aws.Config
+	offline   bool
+	newEc2API ec2APIFactory
+	ec2API    EC2API
 
-func Initialize(awsProfile string) error {
-	if awsProfile != "" {
-		os.Setenv("AWS_PROFILE", awsProfile)
+	regionalApisMutex sync.Mutex
+	regionalApis      map[string]EC2API
+}
+
+func Initialize(ctx context.Context, awsProfile string, options model.AwsOptions) (*Session, error) {
+	if options.Ec2Fixture != "" {
+		return initializeFake(ctx, awsProfile, options)
+	}
 
+	if awsProfile != "" {
 		// --profile command line argument is stronger than those environment variables
 		//
 		os.Unsetenv("AWS_ACCESS_KEY_ID")
@@ -26,61 +42,210 @@ func Initialize(awsProfile string) error {
 		os.Unsetenv("AWS_DEFAULT_REGION")
 	}
 
-	var err error
+	awsConfig, err := initializeAwsConfig(ctx, awsProfile, options)
+
+	if err != nil && loginOnExpiredConfig(ctx, awsProfile, options, err) {
+		awsConfig, err = initializeAwsConfig(ctx, awsProfile, options)
+	}
 
-	ec2Client, err = initializeEc2Client()
-	return err
+	if err != nil {
+		return nil, err
+	}
+
+	return newSession(ctx, awsProfile, options, awsConfig, newAwsEc2API), nil
+}
+
+func newSession(ctx context.Context, awsProfile string, options model.AwsOptions, awsConfig aws.Config, newEc2API ec2APIFactory) *Session {
+	return &Session{
+		Profile:      awsProfile,
+		ctx:          ctx,
+		options:      options,
+		awsConfig:    awsConfig,
+		newEc2API:    newEc2API,
+		ec2API:       newEc2API(awsConfig, ""),
+		regionalApis: make(map[string]EC2API),
+	}
 }
 
-func initializeAwsConfig() (aws.Config, error) {
-	config, err := config.LoadDefaultConfig(context.TODO())
+func initializeAwsConfig(ctx context.Context, awsProfile string, options model.AwsOptions) (aws.Config, error) {
+	config, err := config.LoadDefaultConfig(ctx,
+		config.WithSharedConfigProfile(awsProfile),
+		config.WithRetryer(newRetryer(options)),
+	)
 
 	if err != nil {
 		log.Printf("Unable to load SDK config, %v\n", err)
 		return config, err
 	}
 
-	creds, err := config.Credentials.Retrieve(context.TODO())
+	if options.EndpointUrl != "" {
+		log.Printf("Using custom AWS endpoint %v\n", options.EndpointUrl)
+		config.BaseEndpoint = aws.String(options.EndpointUrl)
+	}
+
+	if options.SkipCredentialsCheck {
+		log.Printf("AWS Config initialized without credentials check, profile: %v, region: %v\n", awsProfile, config.Region)
+		return config, nil
+	}
+
+	callCtx, cancel := withTimeout(ctx, options.Timeout)
+	defer cancel()
+
+	creds, err := config.Credentials.Retrieve(callCtx)
 
 	if err != nil {
 		log.Printf("Unable to get credentials %v", err)
 		return config, err
 	}
 
-	log.Printf("AWS Config initialized, region: %v, access key: %v\n", config.Region, creds.AccessKeyID)
+	log.Printf("AWS Config initialized, profile: %v, region: %v, access key: %v\n", awsProfile, config.Region, creds.AccessKeyID)
 	return config, nil
 }
 
-func initializeEc2Client() (*ec2.Client, error) {
-	awsConfig, err := initializeAwsConfig()
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (session *Session) callContext() (context.Context, context.CancelFunc) {
+	return withTimeout(session.ctx, session.options.Timeout)
+}
+
+func (session *Session) DefaultRegion() string {
+	return session.awsConfig.Region
+}
+
+func (session *Session) getRegionalApi(region string) EC2API {
+	if region == "" || region == session.awsConfig.Region {
+		return session.ec2API
+	}
+
+	session.regionalApisMutex.Lock()
+	defer session.regionalApisMutex.Unlock()
+
+	if api, found := session.regionalApis[region]; found {
+		return api
+	}
+
+	api := session.newEc2API(session.awsConfig, region)
+	session.regionalApis[region] = api
+	return api
+}
+
+func (session *Session) DescribeRegions() ([]string, error) {
+	output, err := session.describeRegions()
+
+	if err != nil && session.loginOnExpiredCredentials(err) {
+		output, err = session.describeRegions()
+	}
 
 	if err != nil {
+		log.Printf("Error getting aws regions: %v\n", err)
 		return nil, err
 	}
 
-	return ec2.NewFromConfig(awsConfig), nil
+	regions := []string{}
+
+	for _, region := range output.Regions {
+		regions = append(regions, *region.RegionName)
+	}
+
+	return regions, nil
+}
+
+func (session *Session) describeRegions() (*ec2.DescribeRegionsOutput, error) {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	return session.ec2API.DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
 }
 
-func describeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
-	instances, err := ec2Client.DescribeInstances(context.TODO(), input)
+func (session *Session) describeInstances(region string, input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	instances, err := session.describeInstancesPage(region, input)
+
+	if err != nil && session.loginOnExpiredCredentials(err) {
+		instances, err = session.describeInstancesPage(region, input)
+	}
 
 	if err != nil {
-		log.Printf("Error getting aws instances: %v\n", err)
+		log.Printf("Error getting aws instances in region %v: %v\n", region, err)
 		return instances, err
 	}
 
 	return instances, nil
 }
 
-func DescribeInstances() (*ec2.DescribeInstancesOutput, error) {
-	input := &ec2.DescribeInstancesInput{}
-	return describeInstances(input)
+func (session *Session) DescribeInstances(region string, filters []types.Filter) ([]types.Reservation, error) {
+	input := &ec2.DescribeInstancesInput{Filters: filters}
+	paginator := ec2.NewDescribeInstancesPaginator(session.getRegionalApi(region), input)
+
+	reservations := []types.Reservation{}
+	pagesCount := 0
+	instancesCount := 0
+
+	for paginator.HasMorePages() {
+		page, err := session.nextDescribeInstancesPage(paginator)
+
+		if err != nil && session.loginOnExpiredCredentials(err) {
+			page, err = session.nextDescribeInstancesPage(paginator)
+		}
+
+		if err != nil {
+			log.Printf("Error getting aws instances in region %v, page %v: %v\n", region, pagesCount+1, err)
+			return nil, err
+		}
+
+		pagesCount++
+
+		for _, reservation := range page.Reservations {
+			instancesCount += len(reservation.Instances)
+		}
+
+		reservations = append(reservations, page.Reservations...)
+	}
+
+	log.Printf("Described %v instances in %v pages, profile %v, region %v\n", instancesCount, pagesCount, session.Profile, region)
+	return reservations, nil
+}
+
+func (session *Session) nextDescribeInstancesPage(paginator *ec2.DescribeInstancesPaginator) (*ec2.DescribeInstancesOutput, error) {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	return paginator.NextPage(ctx)
+}
+
+func (session *Session) describeInstancesPage(region string, input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	return session.getRegionalApi(region).DescribeInstances(ctx, input)
+}
+
+// The primary IPv6 address of the instance, otherwise the first IPv6 address of its network interfaces
+func FindIpv6Address(instance *types.Instance) *string {
+	if aws.ToString(instance.Ipv6Address) != "" {
+		return instance.Ipv6Address
+	}
+
+	for _, networkInterface := range instance.NetworkInterfaces {
+		for _, ipv6Address := range networkInterface.Ipv6Addresses {
+			if aws.ToString(ipv6Address.Ipv6Address) != "" {
+				return ipv6Address.Ipv6Address
+			}
+		}
+	}
+
+	return nil
 }
 
-func DescribeInstance(instanceId string) (*types.Instance, error) {
+func (session *Session) DescribeInstance(region string, instanceId string) (*types.Instance, error) {
 	input := &ec2.DescribeInstancesInput{}
 	input.InstanceIds = append(input.InstanceIds, instanceId)
-	output, error := describeInstances(input)
+	output, error := session.describeInstances(region, input)
 
 	if error != nil {
 		return nil, error
@@ -0,0 +1,521 @@
+package ec2client
+
+import (
+	"aws-bassh/pkg/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// A JSON fixture of instances and images per region, they use the EC2 API field names, e.g.
+//
+//	{
+//		"DefaultRegion": "us-east-1",
+//		"Regions": {
+//			"us-east-1": [
+//				{ "InstanceId": "i-1", "State": { "Name": "running" }, "PublicIpAddress": "1.2.3.4",
+//				  "Tags": [ { "Key": "Name", "Value": "web1" } ] }
+//			]
+//		},
+//		"Images": {
+//			"us-east-1": [ { "ImageId": "ami-1", "Name": "ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server" } ]
+//		},
+//		"VpcPeerings": {
+//			"us-east-1": [ { "VpcPeeringConnectionId": "pcx-1", "Status": { "Code": "active" },
+//			  "RequesterVpcInfo": { "VpcId": "vpc-1" }, "AccepterVpcInfo": { "VpcId": "vpc-2" } } ]
+//		},
+//		"TransitGatewayAttachments": {
+//			"us-east-1": [ { "TransitGatewayId": "tgw-1", "ResourceType": "vpc", "ResourceId": "vpc-3", "State": "available" } ]
+//		},
+//		"InstanceConnectEndpoints": {
+//			"us-east-1": [ { "InstanceConnectEndpointId": "eice-1", "VpcId": "vpc-1", "State": "create-complete",
+//			  "DnsName": "eice-1.1234abcd.ec2-instance-connect-endpoint.us-east-1.amazonaws.com" } ]
+//		},
+//		"SsmInstances": {
+//			"us-east-1": [ { "InstanceId": "i-1", "PingStatus": "Online" } ]
+//		},
+//		"HostedZones": [
+//			{ "HostedZone": { "Id": "/hostedzone/Z1", "Name": "internal.example.com." },
+//			  "VPCs": [ { "VPCId": "vpc-1", "VPCRegion": "us-east-1" } ],
+//			  "ResourceRecordSets": [ { "Name": "web1.internal.example.com.", "Type": "A",
+//			    "ResourceRecords": [ { "Value": "10.0.0.1" } ] } ] }
+//		]
+//	}
+type fakeEc2Fixture struct {
+	mutex sync.Mutex
+
+	DefaultRegion string
+	Regions       map[string][]types.Instance
+	Images        map[string][]types.Image
+
+	VpcPeerings               map[string][]types.VpcPeeringConnection
+	TransitGatewayAttachments map[string][]types.TransitGatewayAttachment
+	InstanceConnectEndpoints  map[string][]types.Ec2InstanceConnectEndpoint
+	SsmInstances              map[string][]ssmtypes.InstanceInformation
+
+	HostedZones []fakeHostedZone
+}
+
+type fakeHostedZone struct {
+	HostedZone         route53types.HostedZone
+	VPCs               []route53types.VPC
+	ResourceRecordSets []route53types.ResourceRecordSet
+}
+
+type fakeEc2API struct {
+	fixture *fakeEc2Fixture
+	region  string
+}
+
+func initializeFake(ctx context.Context, awsProfile string, options model.AwsOptions) (*Session, error) {
+	fixture, err := loadFakeEc2Fixture(options.Ec2Fixture)
+
+	if err != nil {
+		return nil, err
+	}
+
+	session := newSession(ctx, awsProfile, options, aws.Config{Region: fixture.DefaultRegion}, fixture.newEc2API)
+	session.offline = true
+
+	log.Printf("Fake EC2 initialized, fixture: %v, region: %v\n", options.Ec2Fixture, fixture.DefaultRegion)
+	return session, nil
+}
+
+func loadFakeEc2Fixture(fixtureFile string) (*fakeEc2Fixture, error) {
+	jsonBytes, err := os.ReadFile(fixtureFile)
+
+	if err != nil {
+		log.Printf("Error reading EC2 fixture %v, %v", fixtureFile, err)
+		return nil, err
+	}
+
+	fixture := fakeEc2Fixture{}
+
+	if err := json.Unmarshal(jsonBytes, &fixture); err != nil {
+		log.Printf("Error unmarshalling EC2 fixture %v, %v", fixtureFile, err)
+		return nil, err
+	}
+
+	if fixture.DefaultRegion == "" {
+		log.Printf("EC2 fixture %v is missing DefaultRegion", fixtureFile)
+		return nil, errors.New("EC2 fixture missing DefaultRegion")
+	}
+
+	return &fixture, nil
+}
+
+func (fixture *fakeEc2Fixture) newEc2API(awsConfig aws.Config, region string) EC2API {
+	if region == "" {
+		region = fixture.DefaultRegion
+	}
+
+	return &fakeEc2API{
+		fixture: fixture,
+		region:  region,
+	}
+}
+
+func (api *fakeEc2API) DescribeRegions(ctx context.Context, params *ec2.DescribeRegionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error) {
+	names := []string{}
+
+	for region := range api.fixture.Regions {
+		names = append(names, region)
+	}
+
+	sort.Strings(names)
+
+	output := &ec2.DescribeRegionsOutput{}
+
+	for _, name := range names {
+		output.Regions = append(output.Regions, types.Region{RegionName: aws.String(name)})
+	}
+
+	return output, nil
+}
+
+func (api *fakeEc2API) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	api.fixture.mutex.Lock()
+	defer api.fixture.mutex.Unlock()
+
+	instances := []types.Instance{}
+
+	for _, instance := range api.fixture.Regions[api.region] {
+		if len(params.InstanceIds) > 0 && !containsString(params.InstanceIds, *instance.InstanceId) {
+			continue
+		}
+
+		matched, err := matchesFakeFilters(instance, params.Filters)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !matched {
+			continue
+		}
+
+		instances = append(instances, instance)
+	}
+
+	if len(params.InstanceIds) > 0 && len(instances) == 0 {
+		return nil, fmt.Errorf("InvalidInstanceID.NotFound: The instance IDs %v do not exist in region %v", params.InstanceIds, api.region)
+	}
+
+	output := &ec2.DescribeInstancesOutput{}
+
+	if len(instances) > 0 {
+		output.Reservations = append(output.Reservations, types.Reservation{Instances: instances})
+	}
+
+	return output, nil
+}
+
+func (api *fakeEc2API) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	imageIds := params.ImageIds
+
+	for _, filter := range params.Filters {
+		if *filter.Name == "image-id" {
+			imageIds = append(imageIds, filter.Values...)
+		}
+	}
+
+	output := &ec2.DescribeImagesOutput{}
+
+	for _, image := range api.fixture.Images[api.region] {
+		if len(imageIds) > 0 && !containsString(imageIds, *image.ImageId) {
+			continue
+		}
+
+		output.Images = append(output.Images, image)
+	}
+
+	return output, nil
+}
+
+func (api *fakeEc2API) DescribeVpcPeeringConnections(ctx context.Context, params *ec2.DescribeVpcPeeringConnectionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVpcPeeringConnectionsOutput, error) {
+	output := &ec2.DescribeVpcPeeringConnectionsOutput{}
+
+	for _, peering := range api.fixture.VpcPeerings[api.region] {
+		status := ""
+
+		if peering.Status != nil {
+			status = string(peering.Status.Code)
+		}
+
+		if !matchesFilterValues(params.Filters, "status-code", status) {
+			continue
+		}
+
+		output.VpcPeeringConnections = append(output.VpcPeeringConnections, peering)
+	}
+
+	return output, nil
+}
+
+func (api *fakeEc2API) DescribeTransitGatewayAttachments(ctx context.Context, params *ec2.DescribeTransitGatewayAttachmentsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTransitGatewayAttachmentsOutput, error) {
+	output := &ec2.DescribeTransitGatewayAttachmentsOutput{}
+
+	for _, attachment := range api.fixture.TransitGatewayAttachments[api.region] {
+		if !matchesFilterValues(params.Filters, "resource-type", string(attachment.ResourceType)) {
+			continue
+		}
+
+		if !matchesFilterValues(params.Filters, "state", string(attachment.State)) {
+			continue
+		}
+
+		output.TransitGatewayAttachments = append(output.TransitGatewayAttachments, attachment)
+	}
+
+	return output, nil
+}
+
+func (api *fakeEc2API) DescribeInstanceConnectEndpoints(ctx context.Context, params *ec2.DescribeInstanceConnectEndpointsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceConnectEndpointsOutput, error) {
+	output := &ec2.DescribeInstanceConnectEndpointsOutput{}
+
+	for _, endpoint := range api.fixture.InstanceConnectEndpoints[api.region] {
+		if !matchesFilterValues(params.Filters, "state", string(endpoint.State)) {
+			continue
+		}
+
+		output.InstanceConnectEndpoints = append(output.InstanceConnectEndpoints, endpoint)
+	}
+
+	return output, nil
+}
+
+type fakeRoute53API struct {
+	fixture *fakeEc2Fixture
+}
+
+func (api *fakeRoute53API) GetHostedZone(ctx context.Context, params *route53.GetHostedZoneInput, optFns ...func(*route53.Options)) (*route53.GetHostedZoneOutput, error) {
+	zone := api.findHostedZone(*params.Id)
+
+	if zone == nil {
+		return nil, fmt.Errorf("NoSuchHostedZone: No hosted zone found with ID: %v", *params.Id)
+	}
+
+	return &route53.GetHostedZoneOutput{HostedZone: &zone.HostedZone, VPCs: zone.VPCs}, nil
+}
+
+// The records of a fake zone are listed in a single page
+func (api *fakeRoute53API) ListResourceRecordSets(ctx context.Context, params *route53.ListResourceRecordSetsInput, optFns ...func(*route53.Options)) (*route53.ListResourceRecordSetsOutput, error) {
+	zone := api.findHostedZone(*params.HostedZoneId)
+
+	if zone == nil {
+		return nil, fmt.Errorf("NoSuchHostedZone: No hosted zone found with ID: %v", *params.HostedZoneId)
+	}
+
+	return &route53.ListResourceRecordSetsOutput{ResourceRecordSets: zone.ResourceRecordSets}, nil
+}
+
+func (api *fakeRoute53API) findHostedZone(zoneId string) *fakeHostedZone {
+	for i, zone := range api.fixture.HostedZones {
+		if strings.TrimPrefix(aws.ToString(zone.HostedZone.Id), "/hostedzone/") == strings.TrimPrefix(zoneId, "/hostedzone/") {
+			return &api.fixture.HostedZones[i]
+		}
+	}
+
+	return nil
+}
+
+type fakeInstanceConnectAPI struct {
+	fixture *fakeEc2Fixture
+	region  string
+}
+
+// The fake accepts keys for the running instances of the fixture
+func (api *fakeInstanceConnectAPI) SendSSHPublicKey(ctx context.Context, params *ec2instanceconnect.SendSSHPublicKeyInput, optFns ...func(*ec2instanceconnect.Options)) (*ec2instanceconnect.SendSSHPublicKeyOutput, error) {
+	api.fixture.mutex.Lock()
+	defer api.fixture.mutex.Unlock()
+
+	region := api.region
+
+	if region == "" {
+		region = api.fixture.DefaultRegion
+	}
+
+	for _, instance := range api.fixture.Regions[region] {
+		if *instance.InstanceId != *params.InstanceId {
+			continue
+		}
+
+		if instance.State == nil || instance.State.Name != types.InstanceStateNameRunning {
+			return nil, fmt.Errorf("EC2InstanceStateInvalidException: Instance %v is not in a valid state", *params.InstanceId)
+		}
+
+		return &ec2instanceconnect.SendSSHPublicKeyOutput{RequestId: aws.String("fake"), Success: true}, nil
+	}
+
+	return nil, fmt.Errorf("EC2InstanceNotFoundException: Instance %v not found in region %v", *params.InstanceId, region)
+}
+
+type fakeSsmAPI struct {
+	fixture *fakeEc2Fixture
+	region  string
+}
+
+func (api *fakeSsmAPI) getRegion() string {
+	if api.region == "" {
+		return api.fixture.DefaultRegion
+	}
+
+	return api.region
+}
+
+func (api *fakeSsmAPI) DescribeInstanceInformation(ctx context.Context, params *ssm.DescribeInstanceInformationInput, optFns ...func(*ssm.Options)) (*ssm.DescribeInstanceInformationOutput, error) {
+	output := &ssm.DescribeInstanceInformationOutput{}
+
+	for _, information := range api.fixture.SsmInstances[api.getRegion()] {
+		matched := true
+
+		for _, filter := range params.Filters {
+			if aws.ToString(filter.Key) == "InstanceIds" && !containsString(filter.Values, aws.ToString(information.InstanceId)) {
+				matched = false
+			}
+		}
+
+		if matched {
+			output.InstanceInformationList = append(output.InstanceInformationList, information)
+		}
+	}
+
+	return output, nil
+}
+
+// The fake sessions can't be connected, their stream url is only passed to session-manager-plugin
+func (api *fakeSsmAPI) StartSession(ctx context.Context, params *ssm.StartSessionInput, optFns ...func(*ssm.Options)) (*ssm.StartSessionOutput, error) {
+	for _, information := range api.fixture.SsmInstances[api.getRegion()] {
+		if aws.ToString(information.InstanceId) != aws.ToString(params.Target) {
+			continue
+		}
+
+		if information.PingStatus != ssmtypes.PingStatusOnline {
+			break
+		}
+
+		return &ssm.StartSessionOutput{
+			SessionId:  aws.String("fake-" + *params.Target),
+			StreamUrl:  aws.String("wss://ssmmessages." + api.getRegion() + ".amazonaws.com/v1/data-channel/fake-" + *params.Target),
+			TokenValue: aws.String("fake-token"),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("TargetNotConnected: %v is not connected", aws.ToString(params.Target))
+}
+
+func (api *fakeSsmAPI) TerminateSession(ctx context.Context, params *ssm.TerminateSessionInput, optFns ...func(*ssm.Options)) (*ssm.TerminateSessionOutput, error) {
+	return &ssm.TerminateSessionOutput{SessionId: params.SessionId}, nil
+}
+
+// State transitions of the fake instances are immediate
+func (api *fakeEc2API) StartInstances(ctx context.Context, params *ec2.StartInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error) {
+	changes, err := api.setInstancesState(params.InstanceIds, types.InstanceStateNameRunning)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ec2.StartInstancesOutput{StartingInstances: changes}, nil
+}
+
+func (api *fakeEc2API) StopInstances(ctx context.Context, params *ec2.StopInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error) {
+	changes, err := api.setInstancesState(params.InstanceIds, types.InstanceStateNameStopped)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ec2.StopInstancesOutput{StoppingInstances: changes}, nil
+}
+
+func (api *fakeEc2API) RebootInstances(ctx context.Context, params *ec2.RebootInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RebootInstancesOutput, error) {
+	if _, err := api.setInstancesState(params.InstanceIds, types.InstanceStateNameRunning); err != nil {
+		return nil, err
+	}
+
+	return &ec2.RebootInstancesOutput{}, nil
+}
+
+func (api *fakeEc2API) setInstancesState(instanceIds []string, state types.InstanceStateName) ([]types.InstanceStateChange, error) {
+	api.fixture.mutex.Lock()
+	defer api.fixture.mutex.Unlock()
+
+	changes := []types.InstanceStateChange{}
+	instances := api.fixture.Regions[api.region]
+
+	for i := range instances {
+		if !containsString(instanceIds, *instances[i].InstanceId) {
+			continue
+		}
+
+		changes = append(changes, types.InstanceStateChange{
+			InstanceId:    instances[i].InstanceId,
+			PreviousState: instances[i].State,
+			CurrentState:  &types.InstanceState{Name: state},
+		})
+
+		instances[i].State = &types.InstanceState{Name: state}
+	}
+
+	if len(changes) != len(instanceIds) {
+		return nil, fmt.Errorf("InvalidInstanceID.NotFound: The instance IDs %v do not exist in region %v", instanceIds, api.region)
+	}
+
+	return changes, nil
+}
+
+// A subset of the EC2 instance filters, values may contain * and ? wildcards like in EC2
+func matchesFakeFilters(instance types.Instance, filters []types.Filter) (bool, error) {
+	for _, filter := range filters {
+		value, err := fakeFilterValue(instance, *filter.Name)
+
+		if err != nil {
+			return false, err
+		}
+
+		if value == nil || !matchesAnyPattern(filter.Values, *value) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func fakeFilterValue(instance types.Instance, name string) (*string, error) {
+	if strings.HasPrefix(name, "tag:") {
+		for _, tag := range instance.Tags {
+			if *tag.Key == strings.TrimPrefix(name, "tag:") {
+				return tag.Value, nil
+			}
+		}
+
+		return nil, nil
+	}
+
+	switch name {
+	case "instance-id":
+		return instance.InstanceId, nil
+	case "instance-type":
+		return aws.String(string(instance.InstanceType)), nil
+	case "instance-state-name":
+		return aws.String(string(instance.State.Name)), nil
+	case "vpc-id":
+		return instance.VpcId, nil
+	case "subnet-id":
+		return instance.SubnetId, nil
+	case "image-id":
+		return instance.ImageId, nil
+	case "key-name":
+		return instance.KeyName, nil
+	default:
+		return nil, fmt.Errorf("InvalidParameterValue: The filter '%v' is not supported by the EC2 fixture", name)
+	}
+}
+
+// A value matches when the filter is missing, or when it matches one of the filter values
+func matchesFilterValues(filters []types.Filter, name string, value string) bool {
+	for _, filter := range filters {
+		if *filter.Name == name && !matchesAnyPattern(filter.Values, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesAnyPattern(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
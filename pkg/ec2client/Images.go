@@ -0,0 +1,62 @@
+package ec2client
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const describeImagesBatchSize = 100
+
+// Images which are not visible to the account (e.g. deregistered) are silently missing from the result,
+// the image-id filter is used since unknown ImageIds fail the whole request
+func (session *Session) DescribeImages(region string, imageIds []string) ([]types.Image, error) {
+	images := []types.Image{}
+
+	for start := 0; start < len(imageIds); start += describeImagesBatchSize {
+		end := start + describeImagesBatchSize
+
+		if end > len(imageIds) {
+			end = len(imageIds)
+		}
+
+		batch, err := session.describeImagesBatch(region, imageIds[start:end])
+
+		if err != nil {
+			return nil, err
+		}
+
+		images = append(images, batch...)
+	}
+
+	log.Printf("Described %v of %v images, profile %v, region %v\n", len(images), len(imageIds), session.Profile, region)
+	return images, nil
+}
+
+func (session *Session) describeImagesBatch(region string, imageIds []string) ([]types.Image, error) {
+	input := &ec2.DescribeImagesInput{
+		Filters: []types.Filter{{Name: aws.String("image-id"), Values: imageIds}},
+	}
+
+	output, err := session.describeImages(region, input)
+
+	if err != nil && session.loginOnExpiredCredentials(err) {
+		output, err = session.describeImages(region, input)
+	}
+
+	if err != nil {
+		log.Printf("Error getting aws images in region %v: %v\n", region, err)
+		return nil, err
+	}
+
+	return output.Images, nil
+}
+
+func (session *Session) describeImages(region string, input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	return session.getRegionalApi(region).DescribeImages(ctx, input)
+}
@@ -0,0 +1,57 @@
+package ec2client
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+)
+
+// The EC2 Instance Connect calls used by awsbassh, implemented by *ec2instanceconnect.Client and by the fake backend
+type InstanceConnectAPI interface {
+	SendSSHPublicKey(ctx context.Context, params *ec2instanceconnect.SendSSHPublicKeyInput, optFns ...func(*ec2instanceconnect.Options)) (*ec2instanceconnect.SendSSHPublicKeyOutput, error)
+}
+
+func (session *Session) getInstanceConnectApi(region string) InstanceConnectAPI {
+	if fakeApi, ok := session.ec2API.(*fakeEc2API); ok {
+		return &fakeInstanceConnectAPI{fixture: fakeApi.fixture, region: region}
+	}
+
+	return ec2instanceconnect.NewFromConfig(session.awsConfig, func(options *ec2instanceconnect.Options) {
+		if region != "" {
+			options.Region = region
+		}
+	})
+}
+
+// The key is authorized for the user for 60 seconds, enough to open an ssh connection
+func (session *Session) SendSSHPublicKey(region string, instanceId string, user string, publicKey string) error {
+	input := &ec2instanceconnect.SendSSHPublicKeyInput{
+		InstanceId:     aws.String(instanceId),
+		InstanceOSUser: aws.String(user),
+		SSHPublicKey:   aws.String(publicKey),
+	}
+
+	err := session.sendSSHPublicKey(region, input)
+
+	if err != nil && session.loginOnExpiredCredentials(err) {
+		err = session.sendSSHPublicKey(region, input)
+	}
+
+	if err != nil {
+		log.Printf("Error sending ssh public key to instance %v, user %v: %v\n", instanceId, user, err)
+		return err
+	}
+
+	log.Printf("Sent ssh public key to instance %v, user %v\n", instanceId, user)
+	return nil
+}
+
+func (session *Session) sendSSHPublicKey(region string, input *ec2instanceconnect.SendSSHPublicKeyInput) error {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	_, err := session.getInstanceConnectApi(region).SendSSHPublicKey(ctx, input)
+	return err
+}
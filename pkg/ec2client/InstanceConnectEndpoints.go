@@ -0,0 +1,131 @@
+package ec2client
+
+import (
+	"aws-bassh/pkg/model"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	instanceConnectService = "ec2-instance-connect"
+	tunnelUrlExpiry        = 60 * time.Second
+
+	// The SHA-256 of an empty payload, the open tunnel request has no body
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// Only the endpoints which are ready to open tunnels are described
+func (session *Session) DescribeInstanceConnectEndpoints(region string) ([]types.Ec2InstanceConnectEndpoint, error) {
+	input := &ec2.DescribeInstanceConnectEndpointsInput{
+		Filters: []types.Filter{{Name: aws.String("state"), Values: []string{string(types.Ec2InstanceConnectEndpointStateCreateComplete)}}},
+	}
+
+	paginator := ec2.NewDescribeInstanceConnectEndpointsPaginator(session.getRegionalApi(region), input)
+	endpoints := []types.Ec2InstanceConnectEndpoint{}
+
+	for paginator.HasMorePages() {
+		page, err := session.nextInstanceConnectEndpointsPage(paginator)
+
+		if err != nil && session.loginOnExpiredCredentials(err) {
+			page, err = session.nextInstanceConnectEndpointsPage(paginator)
+		}
+
+		if err != nil {
+			log.Printf("Error getting aws instance connect endpoints in region %v: %v\n", region, err)
+			return nil, err
+		}
+
+		endpoints = append(endpoints, page.InstanceConnectEndpoints...)
+	}
+
+	log.Printf("Described %v instance connect endpoints, profile %v, region %v\n", len(endpoints), session.Profile, region)
+	return endpoints, nil
+}
+
+func (session *Session) nextInstanceConnectEndpointsPage(paginator *ec2.DescribeInstanceConnectEndpointsPaginator) (*ec2.DescribeInstanceConnectEndpointsOutput, error) {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	return paginator.NextPage(ctx)
+}
+
+// Opens a WebSocket tunnel to the port of the private address through the endpoint, like
+// 'aws ec2-instance-connect open-tunnel'. The tunnel URL is presigned with SigV4
+func (session *Session) OpenEndpointTunnel(region string, endpoint model.InstanceConnectEndpoint, address string, port string, maxDuration time.Duration) (*websocket.Conn, error) {
+	if session.offline {
+		log.Printf("Instance connect endpoint tunnels are not supported with an EC2 fixture")
+		return nil, errors.New("OpenEndpointTunnel offline")
+	}
+
+	tunnelUrl, err := session.presignTunnelUrl(region, endpoint, address, port, maxDuration)
+
+	if err != nil {
+		log.Printf("Error signing the tunnel url of endpoint %v, %v", endpoint.Id, err)
+		return nil, err
+	}
+
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	conn, response, err := websocket.DefaultDialer.DialContext(ctx, tunnelUrl, nil)
+
+	if err != nil && response != nil {
+		err = fmt.Errorf("%v, %v", err, response.Status)
+	}
+
+	if err != nil {
+		log.Printf("Error opening a tunnel to %v:%v through endpoint %v, %v", address, port, endpoint.Id, err)
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (session *Session) presignTunnelUrl(region string, endpoint model.InstanceConnectEndpoint, address string, port string, maxDuration time.Duration) (string, error) {
+	query := url.Values{}
+	query.Set("instanceConnectEndpointId", endpoint.Id)
+	query.Set("remotePort", port)
+	query.Set("privateIpAddress", address)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(tunnelUrlExpiry.Seconds())))
+
+	if maxDuration > 0 {
+		query.Set("maxTunnelDuration", strconv.Itoa(int(maxDuration.Seconds())))
+	}
+
+	tunnelUrl := url.URL{Scheme: "https", Host: endpoint.DnsName, Path: "/openTunnel", RawQuery: query.Encode()}
+
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, tunnelUrl.String(), nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	creds, err := session.awsConfig.Credentials.Retrieve(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	signedUrl, _, err := v4.NewSigner().PresignHTTP(ctx, creds, request, emptyPayloadHash, instanceConnectService, region, time.Now())
+
+	if err != nil {
+		return "", err
+	}
+
+	return "wss" + strings.TrimPrefix(signedUrl, "https"), nil
+}
@@ -0,0 +1,138 @@
+package ec2client
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const waitForStatePollInterval = 5 * time.Second
+
+func (session *Session) StartInstance(region string, instanceId string) error {
+	input := &ec2.StartInstancesInput{InstanceIds: []string{instanceId}}
+	err := session.startInstances(region, input)
+
+	if err != nil && session.loginOnExpiredCredentials(err) {
+		err = session.startInstances(region, input)
+	}
+
+	if err != nil {
+		log.Printf("Error starting instance %v in region %v: %v\n", instanceId, region, err)
+		return err
+	}
+
+	log.Printf("Starting instance %v", instanceId)
+	return nil
+}
+
+func (session *Session) StopInstance(region string, instanceId string) error {
+	input := &ec2.StopInstancesInput{InstanceIds: []string{instanceId}}
+	err := session.stopInstances(region, input)
+
+	if err != nil && session.loginOnExpiredCredentials(err) {
+		err = session.stopInstances(region, input)
+	}
+
+	if err != nil {
+		log.Printf("Error stopping instance %v in region %v: %v\n", instanceId, region, err)
+		return err
+	}
+
+	log.Printf("Stopping instance %v", instanceId)
+	return nil
+}
+
+func (session *Session) RebootInstance(region string, instanceId string) error {
+	input := &ec2.RebootInstancesInput{InstanceIds: []string{instanceId}}
+	err := session.rebootInstances(region, input)
+
+	if err != nil && session.loginOnExpiredCredentials(err) {
+		err = session.rebootInstances(region, input)
+	}
+
+	if err != nil {
+		log.Printf("Error rebooting instance %v in region %v: %v\n", instanceId, region, err)
+		return err
+	}
+
+	log.Printf("Rebooting instance %v", instanceId)
+	return nil
+}
+
+func (session *Session) startInstances(region string, input *ec2.StartInstancesInput) error {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	_, err := session.getRegionalApi(region).StartInstances(ctx, input)
+	return err
+}
+
+func (session *Session) stopInstances(region string, input *ec2.StopInstancesInput) error {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	_, err := session.getRegionalApi(region).StopInstances(ctx, input)
+	return err
+}
+
+func (session *Session) rebootInstances(region string, input *ec2.RebootInstancesInput) error {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	_, err := session.getRegionalApi(region).RebootInstances(ctx, input)
+	return err
+}
+
+// A stopping instance can't be started yet, it is started once it is stopped
+func (session *Session) StartAndWait(region string, instance *types.Instance, maxWait time.Duration) (*types.Instance, error) {
+	if instance.State.Name == types.InstanceStateNameStopping {
+		if _, err := session.WaitForState(region, *instance.InstanceId, types.InstanceStateNameStopped, maxWait); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := session.StartInstance(region, *instance.InstanceId); err != nil {
+		return nil, err
+	}
+
+	return session.WaitForState(region, *instance.InstanceId, types.InstanceStateNameRunning, maxWait)
+}
+
+func (session *Session) WaitForState(region string, instanceId string, state types.InstanceStateName, maxWait time.Duration) (*types.Instance, error) {
+	deadline := time.Now().Add(maxWait)
+
+	for {
+		instance, err := session.DescribeInstance(region, instanceId)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if instance.State.Name == state {
+			log.Printf("Instance %v is %v", instanceId, state)
+			return instance, nil
+		}
+
+		if instance.State.Name == types.InstanceStateNameTerminated {
+			log.Printf("Instance %v is terminated, stop waiting for %v", instanceId, state)
+			return nil, errors.New("WaitForState instance terminated")
+		}
+
+		if time.Now().After(deadline) {
+			log.Printf("Timeout waiting for instance %v to be %v, it is %v", instanceId, state, instance.State.Name)
+			return nil, fmt.Errorf("WaitForState timeout after %v", maxWait)
+		}
+
+		log.Printf("Instance %v is %v, waiting for %v...", instanceId, instance.State.Name, state)
+
+		select {
+		case <-session.ctx.Done():
+			return nil, session.ctx.Err()
+		case <-time.After(waitForStatePollInterval):
+		}
+	}
+}
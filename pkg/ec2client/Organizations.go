@@ -0,0 +1,73 @@
+package ec2client
+
+import (
+	"errors"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+)
+
+type OrganizationAccount struct {
+	Id        string
+	Name      string
+	Partition string
+}
+
+func (session *Session) ListOrganizationAccounts() ([]OrganizationAccount, error) {
+	if session.offline {
+		log.Printf("Listing organization accounts is not supported with an EC2 fixture")
+		return nil, errors.New("ListOrganizationAccounts offline")
+	}
+
+	client := organizations.NewFromConfig(session.awsConfig)
+	paginator := organizations.NewListAccountsPaginator(client, &organizations.ListAccountsInput{})
+	accounts := []OrganizationAccount{}
+
+	for paginator.HasMorePages() {
+		page, err := session.nextListAccountsPage(paginator)
+
+		if err != nil && session.loginOnExpiredCredentials(err) {
+			page, err = session.nextListAccountsPage(paginator)
+		}
+
+		if err != nil {
+			log.Printf("Error listing organization accounts: %v\n", err)
+			return nil, err
+		}
+
+		for _, account := range page.Accounts {
+			if account.Status != types.AccountStatusActive {
+				log.Printf("Skipping organization account %v (%v), status: %v", *account.Id, *account.Name, account.Status)
+				continue
+			}
+
+			accounts = append(accounts, makeOrganizationAccount(account))
+		}
+	}
+
+	log.Printf("Found %v active accounts in the organization", len(accounts))
+	return accounts, nil
+}
+
+func (session *Session) nextListAccountsPage(paginator *organizations.ListAccountsPaginator) (*organizations.ListAccountsOutput, error) {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	return paginator.NextPage(ctx)
+}
+
+func makeOrganizationAccount(account types.Account) OrganizationAccount {
+	partition := "aws"
+
+	if accountArn, err := arn.Parse(*account.Arn); err == nil {
+		partition = accountArn.Partition
+	}
+
+	return OrganizationAccount{
+		Id:        *account.Id,
+		Name:      *account.Name,
+		Partition: partition,
+	}
+}
@@ -0,0 +1,70 @@
+package ec2client
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+const profileSectionPrefix = "profile "
+
+func ListProfiles() ([]string, error) {
+	configFile := getSharedConfigFile()
+	file, err := os.Open(configFile)
+
+	if err != nil {
+		log.Printf("Error openning aws config file %v, %v", configFile, err)
+		return nil, err
+	}
+
+	defer file.Close()
+
+	profiles := []string{}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		if profile, found := parseProfileSection(scanner.Text()); found {
+			profiles = append(profiles, profile)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading aws config file %v, %v", configFile, err)
+		return nil, err
+	}
+
+	return profiles, nil
+}
+
+func getSharedConfigFile() string {
+	if configFile := os.Getenv("AWS_CONFIG_FILE"); configFile != "" {
+		return configFile
+	}
+
+	return config.DefaultSharedConfigFilename()
+}
+
+// Sections in ~/.aws/config are either [default] or [profile <name>],
+// other sections (e.g. [sso-session <name>]) are not profiles.
+func parseProfileSection(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+
+	if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+		return "", false
+	}
+
+	section := strings.TrimSpace(line[1 : len(line)-1])
+
+	if section == "default" {
+		return section, true
+	}
+
+	if strings.HasPrefix(section, profileSectionPrefix) {
+		return strings.TrimSpace(strings.TrimPrefix(section, profileSectionPrefix)), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,35 @@
+package ec2client
+
+import (
+	"aws-bassh/pkg/model"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+type loggingRetryer struct {
+	aws.RetryerV2
+}
+
+func newRetryer(options model.AwsOptions) func() aws.Retryer {
+	return func() aws.Retryer {
+		standard := retry.NewStandard(func(standardOptions *retry.StandardOptions) {
+			standardOptions.MaxAttempts = options.MaxAttempts
+			standardOptions.MaxBackoff = options.MaxBackoff
+		})
+
+		return loggingRetryer{standard}
+	}
+}
+
+func (retryer loggingRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	delay, delayErr := retryer.RetryerV2.RetryDelay(attempt, err)
+
+	if delayErr == nil {
+		log.Printf("AWS call failed, retrying in %v (attempt %v of %v), %v", delay, attempt+1, retryer.MaxAttempts(), err)
+	}
+
+	return delay, delayErr
+}
@@ -0,0 +1,135 @@
+package ec2client
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// The Route 53 calls used by awsbassh, implemented by *route53.Client and by the fake backend
+type Route53API interface {
+	GetHostedZone(ctx context.Context, params *route53.GetHostedZoneInput, optFns ...func(*route53.Options)) (*route53.GetHostedZoneOutput, error)
+	ListResourceRecordSets(ctx context.Context, params *route53.ListResourceRecordSetsInput, optFns ...func(*route53.Options)) (*route53.ListResourceRecordSetsOutput, error)
+}
+
+// An A, AAAA or CNAME record of a hosted zone, the records of a private zone resolve only in its VPCs
+type DnsRecord struct {
+	Name   string
+	Type   string
+	Value  string
+	VpcIds []string
+}
+
+func (session *Session) getRoute53Api() Route53API {
+	if fakeApi, ok := session.ec2API.(*fakeEc2API); ok {
+		return &fakeRoute53API{fixture: fakeApi.fixture}
+	}
+
+	return route53.NewFromConfig(session.awsConfig)
+}
+
+func (session *Session) ListDnsRecords(zoneId string) ([]DnsRecord, error) {
+	api := session.getRoute53Api()
+	zone, err := session.getHostedZone(api, zoneId)
+
+	if err != nil && session.loginOnExpiredCredentials(err) {
+		zone, err = session.getHostedZone(api, zoneId)
+	}
+
+	if err != nil {
+		log.Printf("Error getting hosted zone %v: %v\n", zoneId, err)
+		return nil, err
+	}
+
+	vpcIds := []string{}
+
+	for _, vpc := range zone.VPCs {
+		vpcIds = append(vpcIds, aws.ToString(vpc.VPCId))
+	}
+
+	input := &route53.ListResourceRecordSetsInput{HostedZoneId: aws.String(zoneId)}
+	records := []DnsRecord{}
+
+	for {
+		page, err := session.listResourceRecordSets(api, input)
+
+		if err != nil && session.loginOnExpiredCredentials(err) {
+			page, err = session.listResourceRecordSets(api, input)
+		}
+
+		if err != nil {
+			log.Printf("Error listing records of hosted zone %v: %v\n", zoneId, err)
+			return nil, err
+		}
+
+		for _, recordSet := range page.ResourceRecordSets {
+			records = append(records, makeDnsRecords(recordSet, vpcIds)...)
+		}
+
+		if !page.IsTruncated {
+			break
+		}
+
+		input.StartRecordName = page.NextRecordName
+		input.StartRecordType = page.NextRecordType
+		input.StartRecordIdentifier = page.NextRecordIdentifier
+	}
+
+	log.Printf("Listed %v records of hosted zone %v (%v), profile %v", len(records), zoneId, aws.ToString(zone.HostedZone.Name), session.Profile)
+	return records, nil
+}
+
+func (session *Session) getHostedZone(api Route53API, zoneId string) (*route53.GetHostedZoneOutput, error) {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	return api.GetHostedZone(ctx, &route53.GetHostedZoneInput{Id: aws.String(zoneId)})
+}
+
+func (session *Session) listResourceRecordSets(api Route53API, input *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	return api.ListResourceRecordSets(ctx, input)
+}
+
+// Alias records and escaped names, e.g. wildcards, are skipped
+func makeDnsRecords(recordSet route53types.ResourceRecordSet, vpcIds []string) []DnsRecord {
+	records := []DnsRecord{}
+	name := strings.ToLower(strings.TrimSuffix(aws.ToString(recordSet.Name), "."))
+
+	if strings.Contains(name, "\\") {
+		return records
+	}
+
+	switch recordSet.Type {
+	case route53types.RRTypeA, route53types.RRTypeAaaa, route53types.RRTypeCname:
+	default:
+		return records
+	}
+
+	for _, resourceRecord := range recordSet.ResourceRecords {
+		records = append(records, DnsRecord{
+			Name:   name,
+			Type:   string(recordSet.Type),
+			Value:  NormalizeDnsValue(aws.ToString(resourceRecord.Value)),
+			VpcIds: vpcIds,
+		})
+	}
+
+	return records
+}
+
+// Record values are matched with instance addresses, so IPv6 addresses get their canonical form
+func NormalizeDnsValue(value string) string {
+	if ip := net.ParseIP(value); ip != nil {
+		return ip.String()
+	}
+
+	return strings.ToLower(strings.TrimSuffix(value, "."))
+}
@@ -0,0 +1,110 @@
+package ec2client
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// The Systems Manager calls used by awsbassh, implemented by *ssm.Client and by the fake backend
+type SsmAPI interface {
+	DescribeInstanceInformation(ctx context.Context, params *ssm.DescribeInstanceInformationInput, optFns ...func(*ssm.Options)) (*ssm.DescribeInstanceInformationOutput, error)
+	StartSession(ctx context.Context, params *ssm.StartSessionInput, optFns ...func(*ssm.Options)) (*ssm.StartSessionOutput, error)
+	TerminateSession(ctx context.Context, params *ssm.TerminateSessionInput, optFns ...func(*ssm.Options)) (*ssm.TerminateSessionOutput, error)
+}
+
+func (session *Session) getSsmApi(region string) SsmAPI {
+	if fakeApi, ok := session.ec2API.(*fakeEc2API); ok {
+		return &fakeSsmAPI{fixture: fakeApi.fixture, region: region}
+	}
+
+	return ssm.NewFromConfig(session.awsConfig, func(options *ssm.Options) {
+		if region != "" {
+			options.Region = region
+		}
+	})
+}
+
+// Returns nil when the instance isn't managed by SSM, e.g. it has no agent or no instance profile
+func (session *Session) DescribeSsmInstance(region string, instanceId string) (*ssmtypes.InstanceInformation, error) {
+	input := &ssm.DescribeInstanceInformationInput{
+		Filters: []ssmtypes.InstanceInformationStringFilter{{Key: aws.String("InstanceIds"), Values: []string{instanceId}}},
+	}
+
+	output, err := session.describeInstanceInformation(region, input)
+
+	if err != nil && session.loginOnExpiredCredentials(err) {
+		output, err = session.describeInstanceInformation(region, input)
+	}
+
+	if err != nil {
+		log.Printf("Error getting the SSM information of instance %v: %v\n", instanceId, err)
+		return nil, err
+	}
+
+	if len(output.InstanceInformationList) == 0 {
+		return nil, nil
+	}
+
+	return &output.InstanceInformationList[0], nil
+}
+
+func (session *Session) describeInstanceInformation(region string, input *ssm.DescribeInstanceInformationInput) (*ssm.DescribeInstanceInformationOutput, error) {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	return session.getSsmApi(region).DescribeInstanceInformation(ctx, input)
+}
+
+func (session *Session) StartSsmSession(region string, input *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
+	output, err := session.startSession(region, input)
+
+	if err != nil && session.loginOnExpiredCredentials(err) {
+		output, err = session.startSession(region, input)
+	}
+
+	if err != nil {
+		log.Printf("Error starting an SSM session to %v: %v\n", aws.ToString(input.Target), err)
+		return nil, err
+	}
+
+	log.Printf("Started SSM session %v to %v\n", aws.ToString(output.SessionId), aws.ToString(input.Target))
+	return output, nil
+}
+
+func (session *Session) startSession(region string, input *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	return session.getSsmApi(region).StartSession(ctx, input)
+}
+
+func (session *Session) TerminateSsmSession(region string, sessionId string) error {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	_, err := session.getSsmApi(region).TerminateSession(ctx, &ssm.TerminateSessionInput{SessionId: aws.String(sessionId)})
+
+	if err != nil {
+		log.Printf("Error terminating SSM session %v: %v\n", sessionId, err)
+		return err
+	}
+
+	return nil
+}
+
+// The endpoint session-manager-plugin uses to terminate the session when it ends
+func (session *Session) SsmEndpoint(region string) string {
+	if session.options.EndpointUrl != "" {
+		return session.options.EndpointUrl
+	}
+
+	if region == "" {
+		region = session.awsConfig.Region
+	}
+
+	return "https://ssm." + region + ".amazonaws.com"
+}
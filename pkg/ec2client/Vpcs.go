@@ -0,0 +1,110 @@
+package ec2client
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Two VPCs with private connectivity, by an active peering connection or by a transit gateway
+type VpcConnection struct {
+	VpcId     string
+	PeerVpcId string
+	Via       string
+}
+
+func (session *Session) DescribeVpcPeerings(region string) ([]VpcConnection, error) {
+	input := &ec2.DescribeVpcPeeringConnectionsInput{
+		Filters: []types.Filter{{Name: aws.String("status-code"), Values: []string{string(types.VpcPeeringConnectionStateReasonCodeActive)}}},
+	}
+
+	paginator := ec2.NewDescribeVpcPeeringConnectionsPaginator(session.getRegionalApi(region), input)
+	connections := []VpcConnection{}
+
+	for paginator.HasMorePages() {
+		page, err := session.nextVpcPeeringsPage(paginator)
+
+		if err != nil && session.loginOnExpiredCredentials(err) {
+			page, err = session.nextVpcPeeringsPage(paginator)
+		}
+
+		if err != nil {
+			log.Printf("Error getting aws vpc peering connections in region %v: %v\n", region, err)
+			return nil, err
+		}
+
+		for _, peering := range page.VpcPeeringConnections {
+			if peering.RequesterVpcInfo == nil || peering.AccepterVpcInfo == nil {
+				continue
+			}
+
+			connections = append(connections, VpcConnection{
+				VpcId:     aws.ToString(peering.RequesterVpcInfo.VpcId),
+				PeerVpcId: aws.ToString(peering.AccepterVpcInfo.VpcId),
+				Via:       aws.ToString(peering.VpcPeeringConnectionId),
+			})
+		}
+	}
+
+	return connections, nil
+}
+
+func (session *Session) nextVpcPeeringsPage(paginator *ec2.DescribeVpcPeeringConnectionsPaginator) (*ec2.DescribeVpcPeeringConnectionsOutput, error) {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	return paginator.NextPage(ctx)
+}
+
+// Every pair of VPCs attached to the same transit gateway is connected, the transit gateway
+// route tables are not checked
+func (session *Session) DescribeTransitGatewayVpcs(region string) ([]VpcConnection, error) {
+	input := &ec2.DescribeTransitGatewayAttachmentsInput{
+		Filters: []types.Filter{
+			{Name: aws.String("resource-type"), Values: []string{string(types.TransitGatewayAttachmentResourceTypeVpc)}},
+			{Name: aws.String("state"), Values: []string{string(types.TransitGatewayAttachmentStateAvailable)}},
+		},
+	}
+
+	paginator := ec2.NewDescribeTransitGatewayAttachmentsPaginator(session.getRegionalApi(region), input)
+	gatewayVpcs := make(map[string][]string)
+
+	for paginator.HasMorePages() {
+		page, err := session.nextTransitGatewayAttachmentsPage(paginator)
+
+		if err != nil && session.loginOnExpiredCredentials(err) {
+			page, err = session.nextTransitGatewayAttachmentsPage(paginator)
+		}
+
+		if err != nil {
+			log.Printf("Error getting aws transit gateway attachments in region %v: %v\n", region, err)
+			return nil, err
+		}
+
+		for _, attachment := range page.TransitGatewayAttachments {
+			gatewayId := aws.ToString(attachment.TransitGatewayId)
+			gatewayVpcs[gatewayId] = append(gatewayVpcs[gatewayId], aws.ToString(attachment.ResourceId))
+		}
+	}
+
+	connections := []VpcConnection{}
+
+	for gatewayId, vpcs := range gatewayVpcs {
+		for i := range vpcs {
+			for j := i + 1; j < len(vpcs); j++ {
+				connections = append(connections, VpcConnection{VpcId: vpcs[i], PeerVpcId: vpcs[j], Via: gatewayId})
+			}
+		}
+	}
+
+	return connections, nil
+}
+
+func (session *Session) nextTransitGatewayAttachmentsPage(paginator *ec2.DescribeTransitGatewayAttachmentsPaginator) (*ec2.DescribeTransitGatewayAttachmentsOutput, error) {
+	ctx, cancel := session.callContext()
+	defer cancel()
+
+	return paginator.NextPage(ctx)
+}
@@ -0,0 +1,167 @@
+package inventory
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Instances which were not seen for a while are dropped, e.g. instances terminated long ago no longer
+// show up in DescribeInstances
+const staleInstanceAge = 7 * 24 * time.Hour
+
+type CachedInstance struct {
+	UpdatedAt time.Time
+	Region    string
+	Instance  types.Instance
+}
+
+type inventoryData struct {
+	Instances map[string]CachedInstance
+}
+
+// The instances cache shared by generate, connect and the lifecycle subcommands, an empty file disables saving
+type Inventory struct {
+	mutex     sync.Mutex
+	file      string
+	instances map[string]CachedInstance
+}
+
+func Open(file string) *Inventory {
+	inventory := &Inventory{file: file, instances: make(map[string]CachedInstance)}
+
+	if file == "" {
+		return inventory
+	}
+
+	jsonBytes, err := os.ReadFile(file)
+
+	if os.IsNotExist(err) {
+		return inventory
+	}
+
+	if err != nil {
+		log.Printf("Error reading inventory cache %v, %v", file, err)
+		return inventory
+	}
+
+	data := inventoryData{}
+
+	if err := json.Unmarshal(jsonBytes, &data); err != nil {
+		log.Printf("Error unmarshalling inventory cache %v, ignoring it, %v", file, err)
+		return inventory
+	}
+
+	if data.Instances != nil {
+		inventory.instances = data.Instances
+	}
+
+	return inventory
+}
+
+// Terminated instances are removed, connect can't use them
+func (inventory *Inventory) Add(region string, reservations []types.Reservation) {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+
+	now := time.Now()
+
+	for _, reservation := range reservations {
+		for _, instance := range reservation.Instances {
+			key := instanceKey(region, *instance.InstanceId)
+
+			if instance.State != nil && instance.State.Name == types.InstanceStateNameTerminated {
+				delete(inventory.instances, key)
+				continue
+			}
+
+			inventory.instances[key] = CachedInstance{
+				UpdatedAt: now,
+				Region:    region,
+				Instance:  instance,
+			}
+		}
+	}
+}
+
+func (inventory *Inventory) AddInstance(region string, instance types.Instance) {
+	inventory.Add(region, []types.Reservation{{Instances: []types.Instance{instance}}})
+}
+
+// Returns the cached instance if it was updated within the ttl
+func (inventory *Inventory) Lookup(region string, instanceId string, ttl time.Duration) (*types.Instance, bool) {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+
+	cached, found := inventory.instances[instanceKey(region, instanceId)]
+
+	if !found {
+		return nil, false
+	}
+
+	age := time.Since(cached.UpdatedAt)
+
+	if age > ttl {
+		log.Printf("Cached instance %v is stale, updated %v ago", instanceId, age.Round(time.Second))
+		return nil, false
+	}
+
+	log.Printf("Using cached instance %v, updated %v ago", instanceId, age.Round(time.Second))
+	return &cached.Instance, true
+}
+
+func (inventory *Inventory) Save() bool {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+
+	if inventory.file == "" {
+		return true
+	}
+
+	inventory.pruneStaleInstances()
+	jsonBytes, err := json.Marshal(inventoryData{Instances: inventory.instances})
+
+	if err != nil {
+		log.Printf("Error marshalling inventory cache %v", err)
+		return false
+	}
+
+	if err := os.MkdirAll(filepath.Dir(inventory.file), 0700); err != nil {
+		log.Printf("Error creating inventory cache directory %v, %v", inventory.file, err)
+		return false
+	}
+
+	// Write and rename, so a concurrent connect never reads a partial file
+	//
+	tempFile := inventory.file + ".tmp"
+
+	if err := os.WriteFile(tempFile, jsonBytes, 0600); err != nil {
+		log.Printf("Error writing inventory cache %v, %v", tempFile, err)
+		return false
+	}
+
+	if err := os.Rename(tempFile, inventory.file); err != nil {
+		log.Printf("Error renaming inventory cache %v, %v", tempFile, err)
+		return false
+	}
+
+	log.Printf("Inventory cache saved %v, %v instances", inventory.file, len(inventory.instances))
+	return true
+}
+
+func (inventory *Inventory) pruneStaleInstances() {
+	for key, cached := range inventory.instances {
+		if time.Since(cached.UpdatedAt) > staleInstanceAge {
+			delete(inventory.instances, key)
+		}
+	}
+}
+
+func instanceKey(region string, instanceId string) string {
+	return region + "/" + instanceId
+}
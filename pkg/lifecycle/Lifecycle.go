@@ -0,0 +1,172 @@
+package lifecycle
+
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/inventory"
+	"aws-bassh/pkg/model"
+	"aws-bassh/pkg/prompt"
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func Run(ctx context.Context, inventory *inventory.Inventory, config model.LifecycleConfig) bool {
+	if config.Machine.Id == "" {
+		log.Printf("Missing or invalid --machine-data")
+		return false
+	}
+
+	session, err := initializeMachineSession(ctx, config)
+
+	if err != nil {
+		return false
+	}
+
+	instance, err := session.DescribeInstance(config.Machine.Region, config.Machine.Id)
+
+	if err != nil {
+		return false
+	}
+
+	switch config.Action {
+	case model.StartAction:
+		return start(session, inventory, config, instance)
+	case model.StopAction:
+		return stop(session, inventory, config, instance)
+	case model.RebootAction:
+		return reboot(session, inventory, config, instance)
+	case model.StatusAction:
+		return status(inventory, config, instance)
+	default:
+		log.Printf("Unknown action %v", config.Action)
+		return false
+	}
+}
+
+func initializeMachineSession(ctx context.Context, config model.LifecycleConfig) (*ec2client.Session, error) {
+	role := config.Machine.Role
+
+	if config.AssumeRole != model.NoRole {
+		role = config.AssumeRole
+	}
+
+	return ec2client.InitializeWithRole(ctx, config.AwsProfile, config.AwsOptions, role)
+}
+
+func start(session *ec2client.Session, inventory *inventory.Inventory, config model.LifecycleConfig, instance *types.Instance) bool {
+	if instance.State.Name == types.InstanceStateNameRunning {
+		log.Printf("Machine %v is already running", config.Machine.Name)
+		return status(inventory, config, instance)
+	}
+
+	if config.NoWait {
+		return session.StartInstance(config.Machine.Region, config.Machine.Id) == nil
+	}
+
+	instance, err := session.StartAndWait(config.Machine.Region, instance, config.Timeout)
+
+	if err != nil {
+		return false
+	}
+
+	return status(inventory, config, instance)
+}
+
+func stop(session *ec2client.Session, inventory *inventory.Inventory, config model.LifecycleConfig, instance *types.Instance) bool {
+	if instance.State.Name == types.InstanceStateNameStopped {
+		log.Printf("Machine %v is already stopped", config.Machine.Name)
+		return status(inventory, config, instance)
+	}
+
+	if !confirmAction(config) {
+		return false
+	}
+
+	if err := session.StopInstance(config.Machine.Region, config.Machine.Id); err != nil {
+		return false
+	}
+
+	return waitAndReport(session, inventory, config, types.InstanceStateNameStopped)
+}
+
+func reboot(session *ec2client.Session, inventory *inventory.Inventory, config model.LifecycleConfig, instance *types.Instance) bool {
+	if instance.State.Name != types.InstanceStateNameRunning {
+		log.Printf("Machine %v is %v, only running machines can be rebooted", config.Machine.Name, instance.State.Name)
+		return false
+	}
+
+	if !confirmAction(config) {
+		return false
+	}
+
+	if err := session.RebootInstance(config.Machine.Region, config.Machine.Id); err != nil {
+		return false
+	}
+
+	// A rebooting instance stays running in EC2, there is no state to wait for
+	if config.NoWait {
+		return true
+	}
+
+	log.Printf("Machine %v is restarting its OS, it may take a few minutes until it accepts connections", config.Machine.Name)
+	return status(inventory, config, instance)
+}
+
+func waitAndReport(session *ec2client.Session, inventory *inventory.Inventory, config model.LifecycleConfig, state types.InstanceStateName) bool {
+	if config.NoWait {
+		return true
+	}
+
+	instance, err := session.WaitForState(config.Machine.Region, config.Machine.Id, state, config.Timeout)
+
+	if err != nil {
+		return false
+	}
+
+	return status(inventory, config, instance)
+}
+
+func confirmAction(config model.LifecycleConfig) bool {
+	question := fmt.Sprintf("%v machine %v (%v)?", config.Action, config.Machine.Name, config.Machine.Id)
+
+	if config.Yes || prompt.Confirm(question) {
+		return true
+	}
+
+	log.Printf("Not running %v on machine %v", config.Action, config.Machine.Id)
+	return false
+}
+
+func status(inventory *inventory.Inventory, config model.LifecycleConfig, instance *types.Instance) bool {
+	inventory.AddInstance(config.Machine.Region, *instance)
+	inventory.Save()
+
+	fmt.Println("")
+	fmt.Printf("Machine:     %v (%v)\n", config.Machine.Name, config.Machine.Id)
+	fmt.Printf("Region:      %v\n", config.Machine.Region)
+	fmt.Printf("State:       %v\n", instance.State.Name)
+	fmt.Printf("Type:        %v\n", instance.InstanceType)
+	fmt.Printf("Public IP:   %v\n", valueOrNone(instance.PublicIpAddress))
+	fmt.Printf("Private IP:  %v\n", valueOrNone(instance.PrivateIpAddress))
+
+	if config.Machine.DnsName != "" {
+		fmt.Printf("DNS name:    %v\n", config.Machine.DnsName)
+	}
+
+	if instance.LaunchTime != nil {
+		fmt.Printf("Launched:    %v\n", instance.LaunchTime.Local())
+	}
+
+	fmt.Println("")
+	return true
+}
+
+func valueOrNone(value *string) string {
+	if value == nil {
+		return "-"
+	}
+
+	return *value
+}
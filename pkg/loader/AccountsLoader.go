@@ -0,0 +1,117 @@
+package loader
+
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/inventory"
+	"aws-bassh/pkg/model"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+type accountMachines struct {
+	role     model.AssumeRole
+	machines map[string]model.Machine
+	err      error
+}
+
+func LoadAllMachines(session *ec2client.Session, inventory *inventory.Inventory, config model.GenerateConfig) (map[string]model.Machine, error) {
+	return loadAllMachines(session, inventory, openAmiDistros(config), config)
+}
+
+func loadAllMachines(session *ec2client.Session, inventory *inventory.Inventory, amiDistros *amiDistros, config model.GenerateConfig) (map[string]model.Machine, error) {
+	roles, err := resolveAssumeRoles(session, config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(roles) == 0 {
+		return loadAccountMachines(session, inventory, amiDistros, config)
+	}
+
+	results := loadAccountsConcurrently(session, inventory, amiDistros, roles, config)
+	machines := make(map[string]model.Machine)
+	failures := 0
+
+	for _, result := range results {
+		if result.err != nil {
+			log.Printf("Failed loading machines using role %v, %v", result.role.RoleArn, result.err)
+			failures++
+			continue
+		}
+
+		log.Printf("Loaded %v machines using role %v", len(result.machines), result.role.RoleArn)
+
+		for id, machine := range result.machines {
+			machines[id] = machine
+		}
+	}
+
+	if failures > 0 {
+		log.Printf("Failed loading %v of %v accounts", failures, len(results))
+	}
+
+	if failures == len(results) {
+		return nil, errors.New("Failed loading all accounts")
+	}
+
+	return machines, nil
+}
+
+func resolveAssumeRoles(session *ec2client.Session, config model.GenerateConfig) ([]model.AssumeRole, error) {
+	if !config.Organization {
+		return config.AssumeRoles, nil
+	}
+
+	accounts, err := session.ListOrganizationAccounts()
+
+	if err != nil {
+		return nil, err
+	}
+
+	roles := config.AssumeRoles
+
+	for _, account := range accounts {
+		roleArn := fmt.Sprintf("arn:%s:iam::%s:role/%s", account.Partition, account.Id, config.RoleName)
+		log.Printf("Organization account %v (%v), role %v", account.Id, account.Name, roleArn)
+		roles = append(roles, model.MakeAssumeRole(roleArn, config.RoleExternalId, config.RoleSessionName))
+	}
+
+	return roles, nil
+}
+
+func loadAccountsConcurrently(session *ec2client.Session, inventory *inventory.Inventory, amiDistros *amiDistros, roles []model.AssumeRole, config model.GenerateConfig) []accountMachines {
+	results := make([]accountMachines, len(roles))
+
+	var wg sync.WaitGroup
+
+	for i, role := range roles {
+		wg.Add(1)
+
+		go func(i int, role model.AssumeRole) {
+			defer wg.Done()
+
+			machines, err := loadAssumedRoleMachines(session, inventory, amiDistros, role, config)
+			results[i] = accountMachines{
+				role:     role,
+				machines: machines,
+				err:      err,
+			}
+		}(i, role)
+	}
+
+	wg.Wait()
+	return results
+}
+
+func loadAssumedRoleMachines(session *ec2client.Session, inventory *inventory.Inventory, amiDistros *amiDistros, role model.AssumeRole, config model.GenerateConfig) (map[string]model.Machine, error) {
+	assumedSession, err := session.AssumeRole(role)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return loadAccountMachines(assumedSession, inventory, amiDistros, config)
+}
@@ -1,6 +1,300 @@
 package loader
 
-func getDistroName(ImageId *string) string {
-	// TODO: to be implemented
-	return "ubuntu"
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/model"
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Images which are not visible, e.g. deregistered or of another account, are looked up again after a while
+const missingImageTtl = 24 * time.Hour
+
+type cachedImage struct {
+	Name      string
+	Distro    string
+	Missing   bool      `json:",omitempty"`
+	CheckedAt time.Time `json:",omitempty"`
+}
+
+type amiMapping struct {
+	pattern string
+	distro  string
+}
+
+// The detected distros and the user mappings, shared by the profiles, accounts and regions of a generate
+type amiDistros struct {
+	mutex    sync.Mutex
+	file     string
+	cache    map[string]cachedImage
+	mappings []amiMapping
+}
+
+func openAmiDistros(config model.GenerateConfig) *amiDistros {
+	distros := &amiDistros{file: config.AmiCacheFile, cache: make(map[string]cachedImage)}
+	distros.loadCache()
+	distros.loadMappings(config.AmiMappingFile)
+
+	return distros
+}
+
+// Ordered, the first keyword found in the image name, description, location or platform wins
+var distroKeywords = []struct {
+	keyword string
+	distro  string
+}{
+	{"amzn", "amazon-linux"},
+	{"amazon linux", "amazon-linux"},
+	{"al2023", "amazon-linux"},
+	{"ubuntu", "ubuntu"},
+	{"debian", "debian"},
+	{"centos", "centos"},
+	{"red hat", "rhel"},
+	{"rhel", "rhel"},
+	{"suse", "suse"},
+	{"sles", "suse"},
+	{"fedora", "fedora"},
+}
+
+func resolveDistros(session *ec2client.Session, amiDistros *amiDistros, region string, reservations []types.Reservation, config model.GenerateConfig) map[string]string {
+	distros := make(map[string]string)
+	missing := []string{}
+
+	for _, imageId := range getImageIds(reservations) {
+		if distro, found := amiDistros.getKnownDistro(imageId); found {
+			distros[imageId] = distro
+		} else {
+			missing = append(missing, imageId)
+		}
+	}
+
+	if len(missing) == 0 {
+		return distros
+	}
+
+	images, err := session.DescribeImages(region, missing)
+
+	if err != nil {
+		log.Printf("Unable to detect distros of %v images in region %v, using default distro %v", len(missing), region, config.DefaultDistro)
+		return distros
+	}
+
+	amiDistros.mutex.Lock()
+	defer amiDistros.mutex.Unlock()
+
+	now := time.Now()
+
+	for _, image := range images {
+		cached := cachedImage{
+			Name:      getImageName(image),
+			Distro:    classifyImage(image),
+			CheckedAt: now,
+		}
+
+		amiDistros.cache[*image.ImageId] = cached
+		distros[*image.ImageId] = amiDistros.getMappedDistro(*image.ImageId, cached)
+	}
+
+	// Images which are not visible are cached as well, they get the default distro until they expire
+	//
+	for _, imageId := range missing {
+		if _, found := distros[imageId]; !found {
+			amiDistros.cache[imageId] = cachedImage{Missing: true, CheckedAt: now}
+		}
+	}
+
+	amiDistros.saveCache()
+	return distros
+}
+
+func getImageIds(reservations []types.Reservation) []string {
+	imageIds := []string{}
+	seen := make(map[string]bool)
+
+	for _, reservation := range reservations {
+		for _, instance := range reservation.Instances {
+			if instance.ImageId == nil || seen[*instance.ImageId] {
+				continue
+			}
+
+			seen[*instance.ImageId] = true
+			imageIds = append(imageIds, *instance.ImageId)
+		}
+	}
+
+	return imageIds
+}
+
+func (amiDistros *amiDistros) getKnownDistro(imageId string) (string, bool) {
+	amiDistros.mutex.Lock()
+	defer amiDistros.mutex.Unlock()
+
+	if cached, found := amiDistros.cache[imageId]; found && !cached.isExpired() {
+		return amiDistros.getMappedDistro(imageId, cached), true
+	}
+
+	for _, mapping := range amiDistros.mappings {
+		if mapping.pattern == imageId {
+			return mapping.distro, true
+		}
+	}
+
+	return "", false
+}
+
+// Missing images of caches written before CheckedAt have a zero time, so they expire right away
+func (cached cachedImage) isExpired() bool {
+	return cached.Missing && time.Since(cached.CheckedAt) > missingImageTtl
+}
+
+// User mappings are stronger than the detected distro
+func (amiDistros *amiDistros) getMappedDistro(imageId string, cached cachedImage) string {
+	for _, mapping := range amiDistros.mappings {
+		if mapping.pattern == imageId {
+			return mapping.distro
+		}
+
+		if matched, _ := path.Match(mapping.pattern, cached.Name); matched && cached.Name != "" {
+			return mapping.distro
+		}
+	}
+
+	return cached.Distro
+}
+
+func getImageName(image types.Image) string {
+	if image.Name == nil {
+		return ""
+	}
+
+	return *image.Name
+}
+
+func classifyImage(image types.Image) string {
+	texts := []*string{image.Name, image.Description, image.ImageLocation, image.ImageOwnerAlias, image.PlatformDetails}
+	var sb strings.Builder
+
+	for _, text := range texts {
+		if text != nil {
+			sb.WriteString(strings.ToLower(*text))
+			sb.WriteString(" ")
+		}
+	}
+
+	description := sb.String()
+
+	for _, distroKeyword := range distroKeywords {
+		if strings.Contains(description, distroKeyword.keyword) {
+			return distroKeyword.distro
+		}
+	}
+
+	return ""
+}
+
+func getDistroName(imageId *string, context buildModelContext) string {
+	if imageId != nil {
+		if distro := context.distros[*imageId]; distro != "" {
+			return distro
+		}
+	}
+
+	return context.config.DefaultDistro
+}
+
+func (amiDistros *amiDistros) loadCache() {
+	if amiDistros.file == "" {
+		return
+	}
+
+	jsonBytes, err := os.ReadFile(amiDistros.file)
+
+	if os.IsNotExist(err) {
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error reading AMI cache %v, %v", amiDistros.file, err)
+		return
+	}
+
+	if err := json.Unmarshal(jsonBytes, &amiDistros.cache); err != nil {
+		log.Printf("Error unmarshalling AMI cache %v, ignoring it, %v", amiDistros.file, err)
+		amiDistros.cache = make(map[string]cachedImage)
+	}
+}
+
+func (amiDistros *amiDistros) saveCache() {
+	if amiDistros.file == "" {
+		return
+	}
+
+	jsonBytes, err := json.MarshalIndent(amiDistros.cache, "", "\t")
+
+	if err != nil {
+		log.Printf("Error marshalling AMI cache %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(amiDistros.file), 0700); err != nil {
+		log.Printf("Error creating AMI cache directory %v, %v", amiDistros.file, err)
+		return
+	}
+
+	if err := os.WriteFile(amiDistros.file, jsonBytes, 0600); err != nil {
+		log.Printf("Error writing AMI cache %v, %v", amiDistros.file, err)
+	}
+}
+
+// Each line of the mapping file is an AMI id or an image name pattern, followed by a distro, e.g.
+//
+//	ami-0123456789abcdef0   rhel
+//	acme-golden-*           amazon-linux
+func (amiDistros *amiDistros) loadMappings(mappingFile string) {
+	if mappingFile == "" {
+		return
+	}
+
+	file, err := os.Open(mappingFile)
+
+	if os.IsNotExist(err) {
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error openning AMI mapping file %v, %v", mappingFile, err)
+		return
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) != 2 {
+			log.Printf("Ignoring invalid AMI mapping line %v", line)
+			continue
+		}
+
+		amiDistros.mappings = append(amiDistros.mappings, amiMapping{pattern: fields[0], distro: fields[1]})
+	}
+
+	log.Printf("Loaded %v AMI mappings from %v", len(amiDistros.mappings), mappingFile)
 }
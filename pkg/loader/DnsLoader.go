@@ -0,0 +1,91 @@
+package loader
+
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/model"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Maps the values of the --route53-zones records, IPs and EC2 DNS names, to their records. Failing to list
+// a zone isn't fatal, e.g. a zone of another account, its machines are connected by their IPs
+func resolveDnsRecords(session *ec2client.Session, config model.GenerateConfig) map[string][]ec2client.DnsRecord {
+	dnsRecords := make(map[string][]ec2client.DnsRecord)
+
+	for _, zoneId := range config.Route53Zones {
+		records, err := session.ListDnsRecords(zoneId)
+
+		if err != nil {
+			log.Printf("Skipping hosted zone %v, profile %v", zoneId, session.Profile)
+			continue
+		}
+
+		for _, record := range records {
+			dnsRecords[record.Value] = append(dnsRecords[record.Value], record)
+		}
+	}
+
+	return dnsRecords
+}
+
+// Private addresses are reused across VPCs, so a record of a private address must come from a zone
+// associated with the machine VPC. The shortest name wins, ties are broken alphabetically
+func findDnsRecord(instance types.Instance, context buildModelContext) ec2client.DnsRecord {
+	dnsRecord := ec2client.DnsRecord{}
+
+	for _, address := range []*string{instance.PublicIpAddress, instance.PublicDnsName, ec2client.FindIpv6Address(&instance)} {
+		dnsRecord = betterDnsRecord(dnsRecord, findRecord(aws.ToString(address), nil, context))
+	}
+
+	for _, address := range []*string{instance.PrivateIpAddress, instance.PrivateDnsName} {
+		dnsRecord = betterDnsRecord(dnsRecord, findRecord(aws.ToString(address), instance.VpcId, context))
+	}
+
+	return dnsRecord
+}
+
+func findRecord(address string, vpcId *string, context buildModelContext) ec2client.DnsRecord {
+	found := ec2client.DnsRecord{}
+
+	if address == "" {
+		return found
+	}
+
+	for _, record := range context.dnsRecords[ec2client.NormalizeDnsValue(address)] {
+		if vpcId != nil && len(record.VpcIds) > 0 && !containsVpc(record.VpcIds, *vpcId) {
+			continue
+		}
+
+		found = betterDnsRecord(found, record)
+	}
+
+	return found
+}
+
+func betterDnsRecord(record ec2client.DnsRecord, candidate ec2client.DnsRecord) ec2client.DnsRecord {
+	if record.Name == "" {
+		return candidate
+	}
+
+	if candidate.Name == "" || len(record.Name) < len(candidate.Name) {
+		return record
+	}
+
+	if len(candidate.Name) < len(record.Name) || candidate.Name < record.Name {
+		return candidate
+	}
+
+	return record
+}
+
+func containsVpc(vpcIds []string, vpcId string) bool {
+	for _, candidate := range vpcIds {
+		if candidate == vpcId {
+			return true
+		}
+	}
+
+	return false
+}
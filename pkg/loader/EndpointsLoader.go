@@ -0,0 +1,64 @@
+package loader
+
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/model"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Maps a VPC to its instance connect endpoints. Failing to describe them isn't fatal, e.g. a missing
+// permission, the machines are connected through their bastions
+func resolveInstanceConnectEndpoints(session *ec2client.Session, region string, config model.GenerateConfig) map[string][]types.Ec2InstanceConnectEndpoint {
+	vpcEndpoints := make(map[string][]types.Ec2InstanceConnectEndpoint)
+
+	if !config.EiceEndpoints {
+		return vpcEndpoints
+	}
+
+	endpoints, err := session.DescribeInstanceConnectEndpoints(region)
+
+	if err != nil {
+		return vpcEndpoints
+	}
+
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		return aws.ToString(endpoints[i].InstanceConnectEndpointId) < aws.ToString(endpoints[j].InstanceConnectEndpointId)
+	})
+
+	for _, endpoint := range endpoints {
+		vpcId := aws.ToString(endpoint.VpcId)
+		vpcEndpoints[vpcId] = append(vpcEndpoints[vpcId], endpoint)
+	}
+
+	return vpcEndpoints
+}
+
+// An endpoint reaches every subnet of its VPC, one in the machine availability zone is preferred
+func findInstanceConnectEndpoint(instance types.Instance, context buildModelContext) *model.InstanceConnectEndpoint {
+	if instance.VpcId == nil {
+		return nil
+	}
+
+	endpoints := context.endpoints[*instance.VpcId]
+
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	selected := endpoints[0]
+
+	for _, endpoint := range endpoints {
+		if aws.ToString(endpoint.AvailabilityZone) == getAvailabilityZone(instance) {
+			selected = endpoint
+			break
+		}
+	}
+
+	return &model.InstanceConnectEndpoint{
+		Id:      aws.ToString(selected.InstanceConnectEndpointId),
+		DnsName: aws.ToString(selected.DnsName),
+	}
+}
@@ -0,0 +1,67 @@
+package loader
+
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/model"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const includeRulesName = "include"
+
+func buildEc2Filters(config model.GenerateConfig) []types.Filter {
+	filters := []types.Filter{}
+
+	for _, filter := range config.Ec2Filters {
+		filters = append(filters, types.Filter{
+			Name:   aws.String(filter.Name),
+			Values: filter.Values,
+		})
+	}
+
+	return filters
+}
+
+// Returns the rule that removed the machine, or an empty string when the machine is kept
+func findRemovingRule(machine model.Machine, tags map[string]string, config model.GenerateConfig) string {
+	if len(config.IncludeRules) > 0 && findMatchingRule(machine, tags, config.IncludeRules) == "" {
+		return includeRulesName
+	}
+
+	return findMatchingRule(machine, tags, config.ExcludeRules)
+}
+
+func findMatchingRule(machine model.Machine, tags map[string]string, rules []model.MachineRule) string {
+	for _, rule := range rules {
+		if rule.Matches(machine.Name, tags) {
+			return rule.Rule
+		}
+	}
+
+	return ""
+}
+
+func logRemovedMachines(session *ec2client.Session, region string, removed map[string]int, config model.GenerateConfig) {
+	for _, rule := range config.ExcludeRules {
+		if removed[rule.Rule] > 0 {
+			log.Printf("Exclude rule %v removed %v machines, profile %v, region %v", rule.Rule, removed[rule.Rule], session.Profile, region)
+		}
+	}
+
+	if removed[includeRulesName] > 0 {
+		log.Printf("Include rules %v removed %v machines, profile %v, region %v", joinRules(config.IncludeRules), removed[includeRulesName], session.Profile, region)
+	}
+}
+
+func joinRules(rules []model.MachineRule) string {
+	names := []string{}
+
+	for _, rule := range rules {
+		names = append(names, rule.Rule)
+	}
+
+	return strings.Join(names, ",")
+}
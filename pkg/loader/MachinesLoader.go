@@ -2,52 +2,94 @@ package loader
 
 import (
 	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/inventory"
 	"aws-bassh/pkg/model"
+	"log"
 	"path"
+	"sort"
 	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 )
 
-func LoadAllMachines(config model.GenerateConfig) (map[string]model.Machine, error) {
-	instances, err := ec2client.DescribeInstances()
+func loadRegionMachines(session *ec2client.Session, inventory *inventory.Inventory, amiDistros *amiDistros, region string, dnsRecords map[string][]ec2client.DnsRecord, config model.GenerateConfig) (map[string]model.Machine, error) {
+	reservations, err := session.DescribeInstances(region, buildEc2Filters(config))
 
 	if err != nil {
 		return nil, err
 	}
 
-	return buildModelFromInstances(instances, config)
+	allInstances, err := describeAllInstances(session, region, reservations, config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	inventory.Add(region, allInstances)
+	distros := resolveDistros(session, amiDistros, region, allInstances, config)
+	bastionVpcs := resolveBastionVpcs(session, region, config)
+	endpoints := resolveInstanceConnectEndpoints(session, region, config)
+
+	return buildModelFromInstances(session, region, reservations, allInstances, distros, bastionVpcs, dnsRecords, endpoints, config)
+}
+
+// The --filters select the generated machines only, the bastions are looked up among all the instances of the region
+func describeAllInstances(session *ec2client.Session, region string, reservations []types.Reservation, config model.GenerateConfig) ([]types.Reservation, error) {
+	if len(config.Ec2Filters) == 0 {
+		return reservations, nil
+	}
+
+	return session.DescribeInstances(region, nil)
 }
 
 type buildModelContext struct {
 	config       model.GenerateConfig
-	allInstances *ec2.DescribeInstancesOutput
+	session      *ec2client.Session
+	region       string
+	allInstances []types.Reservation
+	distros      map[string]string
+	bastionVpcs  map[string]map[string]int
+	dnsRecords   map[string][]ec2client.DnsRecord
+	endpoints    map[string][]types.Ec2InstanceConnectEndpoint
 }
 
-func buildModelFromInstances(instances *ec2.DescribeInstancesOutput, config model.GenerateConfig) (map[string]model.Machine, error) {
+func buildModelFromInstances(session *ec2client.Session, region string, reservations []types.Reservation, allInstances []types.Reservation, distros map[string]string, bastionVpcs map[string]map[string]int, dnsRecords map[string][]ec2client.DnsRecord, endpoints map[string][]types.Ec2InstanceConnectEndpoint, config model.GenerateConfig) (map[string]model.Machine, error) {
 	machines := make(map[string]model.Machine)
+	removed := make(map[string]int)
 	context := buildModelContext{
 		config:       config,
-		allInstances: instances,
+		session:      session,
+		region:       region,
+		allInstances: allInstances,
+		distros:      distros,
+		bastionVpcs:  bastionVpcs,
+		dnsRecords:   dnsRecords,
+		endpoints:    endpoints,
 	}
 
-	for _, reservations := range instances.Reservations {
-		for _, instance := range reservations.Instances {
+	for _, reservation := range reservations {
+		for _, instance := range reservation.Instances {
 			if instance.State.Name == types.InstanceStateNameTerminated {
 				continue
 			}
 			
-			if instance.State.Name == types.InstanceStateNameStopped {
+			if instance.State.Name == types.InstanceStateNameStopped && !config.IncludeStopped {
 				continue
 			}
 
-			machine := buildModelForMachine(instance, buildTagsMap(instance), context)
+			tags := buildTagsMap(instance)
+			machine := buildModelForMachine(instance, tags, context)
+
+			if rule := findRemovingRule(machine, tags, config); rule != "" {
+				removed[rule]++
+				continue
+			}
 
 			machines[machine.Id] = machine
 		}
 	}
 
+	logRemovedMachines(session, region, removed, config)
 	return machines, nil
 }
 
@@ -61,23 +103,74 @@ func buildTagsMap(instance types.Instance) map[string]string {
 }
 
 func buildModelForMachine(instance types.Instance, tags map[string]string, context buildModelContext) model.Machine {
+	dnsRecord := findDnsRecord(instance, context)
+
 	return model.Machine{
-		Id:      *instance.InstanceId,
-		Name:    findMachineName(instance, tags, context),
-		User:    findUserName(instance, tags, context),
-		Keyfile: findKeyFile(instance, context),
-		Bastion: findBastion(instance, tags, context),
+		Id:          *instance.InstanceId,
+		Region:      context.region,
+		AccountId:   context.session.AccountId,
+		Role:        context.session.Role,
+		Name:        findMachineName(instance, tags, context),
+		User:        findUserName(instance, tags, context),
+		Keyfile:     findKeyFile(instance, tags, context),
+		Bastions:    findBastions(instance, tags, context),
+		Address:     findAddress(instance, tags, context),
+		DnsName:     dnsRecord.Name,
+		PrivateZone: len(dnsRecord.VpcIds) > 0,
+		Endpoint:    findInstanceConnectEndpoint(instance, context),
+		Ssm:         isSsmMachine(instance, tags, context),
 	}
 }
 
+// The machine name is the Route 53 name with --route53-names, otherwise the name template when set, otherwise
+// the first name tag, otherwise the instance id
 func findMachineName(instance types.Instance, tags map[string]string, context buildModelContext) string {
-	nameFromTag := getFirstTag(context.config.NameTags, tags)
+	nameData := buildMachineNameData(instance, tags, context)
+
+	if context.config.Route53Names && nameData.DnsName != "" {
+		return nameData.DnsName
+	}
+
+	if !context.config.NameTemplate.IsSet() {
+		return nameData.Name
+	}
+
+	name, err := context.config.NameTemplate.Execute(nameData)
+
+	if err != nil {
+		log.Printf("Error executing name template for instance %v, %v", *instance.InstanceId, err)
+		return nameData.Name
+	}
+
+	if name == "" {
+		return nameData.Name
+	}
+
+	return name
+}
+
+func buildMachineNameData(instance types.Instance, tags map[string]string, context buildModelContext) model.MachineNameData {
+	nameData := model.MachineNameData{
+		Name:         getFirstTag(context.config.NameTags, tags),
+		Tags:         tags,
+		AccountId:    context.session.AccountId,
+		Region:       context.region,
+		InstanceId:   *instance.InstanceId,
+		InstanceType: string(instance.InstanceType),
+		DnsName:      findDnsRecord(instance, context).Name,
+	}
+
+	nameData.AvailabilityZone = getAvailabilityZone(instance)
+
+	if nameData.Name == "" {
+		nameData.Name = *instance.InstanceId
+	}
 
-	if nameFromTag != "" {
-		return nameFromTag
+	if instance.PrivateIpAddress != nil {
+		nameData.PrivateIp = *instance.PrivateIpAddress
 	}
 
-	return model.NoMachineName
+	return nameData
 }
 
 func findUserName(instance types.Instance, tags map[string]string, context buildModelContext) string {
@@ -90,7 +183,7 @@ func findUserName(instance types.Instance, tags map[string]string, context build
 	// According to this doc:
 	//	https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/connection-prereqs.html
 	//
-	switch distro := getDistroName(instance.ImageId); distro {
+	switch distro := getDistroName(instance.ImageId, context); distro {
 	case "amazon-linux":
 		return "ec2-user"
 	case "centos":
@@ -110,7 +203,36 @@ func findUserName(instance types.Instance, tags map[string]string, context build
 	}
 }
 
-func findKeyFile(instance types.Instance, context buildModelContext) string {
+// An invalid address tag is ignored, connect uses the default address
+func findAddress(instance types.Instance, tags map[string]string, context buildModelContext) string {
+	address := getFirstTag(context.config.AddressTags, tags)
+
+	if !model.IsValidAddress(address) {
+		log.Printf("Ignoring the address tag of instance %v", *instance.InstanceId)
+		return model.AutoAddress
+	}
+
+	return address
+}
+
+// An unknown connect mode is ignored, the machine is connected with ssh
+func isSsmMachine(instance types.Instance, tags map[string]string, context buildModelContext) bool {
+	mode := strings.ToLower(getFirstTag(context.config.ConnectModeTags, tags))
+
+	if mode != "" && mode != model.SshConnectMode && mode != model.SsmConnectMode {
+		log.Printf("Ignoring connect mode %v of instance %v, expected %v or %v", mode, *instance.InstanceId, model.SshConnectMode, model.SsmConnectMode)
+	}
+
+	return mode == model.SsmConnectMode
+}
+
+func findKeyFile(instance types.Instance, tags map[string]string, context buildModelContext) string {
+	keyFromTag := getFirstTag(context.config.KeyTags, tags)
+
+	if keyFromTag != "" {
+		return buildKeyfile(keyFromTag, context)
+	}
+
 	if instance.KeyName != nil {
 		return buildKeyfile(*instance.KeyName, context)
 	}
@@ -118,90 +240,219 @@ func findKeyFile(instance types.Instance, context buildModelContext) string {
 	return ""
 }
 
-func findBastion(instance types.Instance, tags map[string]string, context buildModelContext) model.BastionMachine {
-	bastionFromTags := getBastionFromTags(instance, tags, context)
+// The bastions chain is the --jump-hosts, followed by the bastions from the tags or the implicit bastion in the VPC
+func findBastions(instance types.Instance, tags map[string]string, context buildModelContext) []model.BastionMachine {
+	chain := model.MakeBastionChain(context.config.JumpHosts, nil, buildKeyfiles(context.config.JumpKeys, context))
+	bastionsFromTags := getBastionsFromTags(instance, tags, context)
+
+	if len(bastionsFromTags) > 0 {
+		return append(chain, bastionsFromTags...)
+	}
+
+	if bastion := findBastionInVpc(instance, context); !bastion.IsEmpty() {
+		chain = append(chain, bastion)
+	}
+
+	return chain
+}
+
+// The bastion tags may hold a chain, e.g. BastionUrl=jump.example.com,10.0.0.9 and BastionUser=alice,ubuntu
+func getBastionsFromTags(instance types.Instance, tags map[string]string, context buildModelContext) []model.BastionMachine {
+	bastionUrls := splitTagValue(getFirstTag(context.config.BastionUrlTags, tags))
+	bastionUsers := splitTagValue(getFirstTag(context.config.BastionUserTags, tags))
+	bastionKeys := splitTagValue(getFirstTag(context.config.BastionKeyNameTags, tags))
+
+	if len(bastionUrls) == 0 {
+		return getBastionReferenceFromTags(instance, tags, context)
+	}
 
-	if bastionFromTags != model.NoBastion {
-		return bastionFromTags
+	if len(bastionUsers) == 0 {
+		return nil
 	}
 
-	return findBastionInVpc(instance, context)
+	if len(bastionKeys) == 0 && instance.KeyName != nil {
+		bastionKeys = []string{*instance.KeyName}
+	}
+
+	return model.MakeBastionChain(bastionUrls, bastionUsers, buildKeyfiles(bastionKeys, context))
 }
 
-func getBastionFromTags(instance types.Instance, tags map[string]string, context buildModelContext) model.BastionMachine {
-	bastionUrl := getFirstTag(context.config.BastionUrlTags, tags)
-	bastionUser := getFirstTag(context.config.BastionUserTags, tags)
-	bastionKey := getFirstTag(context.config.BastionKeyNameTags, tags)
+// A referenced bastion, e.g. BastionInstanceId=i-0123 or BastionName=bastion, is resolved to its current address by
+// connect. Its user and key come from the bastion tags, or from the bastion instance when it is loaded, or from the machine
+func getBastionReferenceFromTags(instance types.Instance, tags map[string]string, context buildModelContext) []model.BastionMachine {
+	bastion := model.BastionMachine{
+		InstanceId: getFirstTag(context.config.BastionIdTags, tags),
+		Name:       getFirstTag(context.config.BastionNameTags, tags),
+		User:       getFirstTag(context.config.BastionUserTags, tags),
+	}
 
-	if bastionUrl == "" {
-		return model.NoBastion
+	if !bastion.IsReference() {
+		return nil
 	}
 
-	if bastionUser == "" {
-		return model.NoBastion
+	if bastion.Name != "" {
+		bastion.NameTags = context.config.NameTags
 	}
 
-	if bastionKey == "" {
-		bastionKey = *instance.KeyName
+	if bastionKey := getFirstTag(context.config.BastionKeyNameTags, tags); bastionKey != "" {
+		bastion.Keyfile = buildKeyfile(bastionKey, context)
 	}
 
-	return model.BastionMachine{
-		Url:     bastionUrl,
-		User:    bastionUser,
-		Keyfile: buildKeyfile(bastionKey, context),
+	referencedInstance, referencedTags := instance, tags
+
+	if bastionInstance := findReferencedBastion(bastion, context); bastionInstance != nil {
+		referencedInstance, referencedTags = *bastionInstance, buildTagsMap(*bastionInstance)
 	}
+
+	if bastion.User == "" {
+		bastion.User = findUserName(referencedInstance, referencedTags, context)
+	}
+
+	if bastion.Keyfile == "" {
+		bastion.Keyfile = findKeyFile(referencedInstance, referencedTags, context)
+	}
+
+	return []model.BastionMachine{bastion}
 }
 
+func findReferencedBastion(bastion model.BastionMachine, context buildModelContext) *types.Instance {
+	for _, reservation := range context.allInstances {
+		for _, candidate := range reservation.Instances {
+			if bastion.InstanceId != "" && *candidate.InstanceId == bastion.InstanceId {
+				return &candidate
+			}
+
+			if bastion.InstanceId == "" && getFirstTag(context.config.NameTags, buildTagsMap(candidate)) == bastion.Name {
+				return &candidate
+			}
+		}
+	}
+
+	return nil
+}
+
+// Candidates are the instances with a public IP in the same VPC, or in a mapped or connected VPC, which match
+// --bastion-rules. The best one is running, in the same VPC and in the same availability zone, ties are broken
+// by name and id
 func findBastionInVpc(instance types.Instance, context buildModelContext) model.BastionMachine {
 	if instance.VpcId == nil {
 		return model.NoBastion
 	}
 
-	for _, reservations := range context.allInstances.Reservations {
-		for _, bastionCandidate := range reservations.Instances {
-			if bastionCandidate.VpcId == nil {
+	candidates := []bastionCandidate{}
+
+	for _, reservation := range context.allInstances {
+		for _, candidate := range reservation.Instances {
+			if candidate.VpcId == nil {
 				continue
 			}
 
-			if bastionCandidate.PublicIpAddress == nil {
+			if candidate.PublicIpAddress == nil {
 				continue
 			}
 
-			if *bastionCandidate.VpcId != *instance.VpcId {
+			vpcRank, found := getBastionVpcRank(*instance.VpcId, *candidate.VpcId, context)
+
+			if !found {
 				continue
 			}
 
-			if !containsBastionInName(bastionCandidate) {
+			tags := buildTagsMap(candidate)
+
+			if !isBastionCandidate(tags, context) {
 				continue
 			}
 
-			return buildModelForBastionMachine(bastionCandidate, buildTagsMap(bastionCandidate), context)
+			candidates = append(candidates, makeBastionCandidate(instance, candidate, tags, vpcRank, context))
 		}
 	}
 
-	return model.NoBastion
+	if len(candidates) == 0 {
+		return model.NoBastion
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].betterThan(candidates[j])
+	})
+
+	// The other candidates are fallbacks, in case the best one is down
+	//
+	bastion := buildModelForBastionMachine(candidates[0].instance, candidates[0].tags, context)
+
+	for _, candidate := range candidates[1:] {
+		bastion.Fallbacks = append(bastion.Fallbacks, buildModelForBastionMachine(candidate.instance, candidate.tags, context))
+	}
+
+	return bastion
 }
 
-func buildModelForBastionMachine(bastion types.Instance, tags map[string]string, context buildModelContext) model.BastionMachine {
-	return model.BastionMachine{
-		Url:     *bastion.PublicIpAddress,
-		User:    findUserName(bastion, tags, context),
-		Keyfile: findKeyFile(bastion, context),
+type bastionCandidate struct {
+	instance types.Instance
+	tags     map[string]string
+	name     string
+	running  bool
+	vpcRank  int
+	sameZone bool
+}
+
+func makeBastionCandidate(instance types.Instance, candidate types.Instance, tags map[string]string, vpcRank int, context buildModelContext) bastionCandidate {
+	return bastionCandidate{
+		instance: candidate,
+		tags:     tags,
+		name:     getFirstTag(context.config.NameTags, tags),
+		running:  candidate.State != nil && candidate.State.Name == types.InstanceStateNameRunning,
+		vpcRank:  vpcRank,
+		sameZone: getAvailabilityZone(candidate) != "" && getAvailabilityZone(candidate) == getAvailabilityZone(instance),
 	}
 }
 
-func containsBastionInName(instance types.Instance) bool {
-	for _, tag := range instance.Tags {
-		if *tag.Key == "Name" {
-			if strings.Contains(strings.ToLower(*tag.Value), "bastion") {
-				return true
-			}
+func (candidate bastionCandidate) betterThan(other bastionCandidate) bool {
+	if candidate.running != other.running {
+		return candidate.running
+	}
+
+	if candidate.vpcRank != other.vpcRank {
+		return candidate.vpcRank < other.vpcRank
+	}
+
+	if candidate.sameZone != other.sameZone {
+		return candidate.sameZone
+	}
+
+	if candidate.name != other.name {
+		return candidate.name < other.name
+	}
+
+	return *candidate.instance.InstanceId < *other.instance.InstanceId
+}
+
+func isBastionCandidate(tags map[string]string, context buildModelContext) bool {
+	for _, rule := range context.config.BastionRules {
+		if rule.Matches(getFirstTag(context.config.NameTags, tags), tags) {
+			return true
 		}
 	}
 
 	return false
 }
 
+func getAvailabilityZone(instance types.Instance) string {
+	if instance.Placement == nil || instance.Placement.AvailabilityZone == nil {
+		return ""
+	}
+
+	return *instance.Placement.AvailabilityZone
+}
+
+func buildModelForBastionMachine(bastion types.Instance, tags map[string]string, context buildModelContext) model.BastionMachine {
+	return model.BastionMachine{
+		Url:        *bastion.PublicIpAddress,
+		User:       findUserName(bastion, tags, context),
+		Keyfile:    findKeyFile(bastion, tags, context),
+		InstanceId: *bastion.InstanceId,
+	}
+}
+
 func getFirstTag(tagNames []string, tags map[string]string) string {
 	for _, tag := range tagNames {
 		if _, found := tags[tag]; found {
@@ -212,6 +463,28 @@ func getFirstTag(tagNames []string, tags map[string]string) string {
 	return ""
 }
 
+func splitTagValue(value string) []string {
+	values := []string{}
+
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+
+	return values
+}
+
+func buildKeyfiles(keynames []string, context buildModelContext) []string {
+	keyfiles := []string{}
+
+	for _, keyname := range keynames {
+		keyfiles = append(keyfiles, buildKeyfile(keyname, context))
+	}
+
+	return keyfiles
+}
+
 func buildKeyfile(keyname string, context buildModelContext) string {
 	return path.Join(context.config.KeysDirectory, keyname+".pem")
 }
@@ -0,0 +1,293 @@
+package loader
+
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/inventory"
+	"aws-bassh/pkg/model"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const testRegion = "us-east-1"
+
+type testFixture struct {
+	DefaultRegion string
+	Regions       map[string][]types.Instance
+	VpcPeerings   map[string][]types.VpcPeeringConnection
+}
+
+func makeTestConfig() model.GenerateConfig {
+	return model.GenerateConfig{
+		KeysDirectory:   "keys",
+		BashAliasPrefix: "ec2_",
+		DefaultDistro:   "ubuntu",
+		BastionRules:    model.MakeMachineRules("tag:Name=/(?i)bastion/"),
+		NameTags:        []string{"Name"},
+		UserTags:        []string{"SSHUser"},
+	}
+}
+
+func makeTestInstance(id string, name string, vpcId string, zone string, publicIp string, state types.InstanceStateName) types.Instance {
+	instance := types.Instance{
+		InstanceId:       aws.String(id),
+		State:            &types.InstanceState{Name: state},
+		VpcId:            aws.String(vpcId),
+		Placement:        &types.Placement{AvailabilityZone: aws.String(zone)},
+		PrivateIpAddress: aws.String("10.0.0.1"),
+		KeyName:          aws.String("main"),
+		Tags:             []types.Tag{{Key: aws.String("Name"), Value: aws.String(name)}},
+	}
+
+	if publicIp != "" {
+		instance.PublicIpAddress = aws.String(publicIp)
+	}
+
+	return instance
+}
+
+// Loads the machines of the fixture with the fake EC2 backend, as generate --ec2-fixture does
+func loadTestMachines(t *testing.T, fixture testFixture, config model.GenerateConfig) map[string]model.Machine {
+	t.Helper()
+
+	fixture.DefaultRegion = testRegion
+	jsonBytes, err := json.Marshal(fixture)
+
+	if err != nil {
+		t.Fatalf("Error marshalling fixture %v", err)
+	}
+
+	fixtureFile := filepath.Join(t.TempDir(), "fixture.json")
+
+	if err := os.WriteFile(fixtureFile, jsonBytes, 0600); err != nil {
+		t.Fatalf("Error writing fixture %v", err)
+	}
+
+	config.AwsOptions = model.AwsOptions{Ec2Fixture: fixtureFile}
+	session, err := ec2client.Initialize(context.Background(), "", config.AwsOptions)
+
+	if err != nil {
+		t.Fatalf("Error initializing fake EC2 %v", err)
+	}
+
+	machines, err := LoadAllMachines(session, inventory.Open(""), config)
+
+	if err != nil {
+		t.Fatalf("Error loading machines %v", err)
+	}
+
+	return machines
+}
+
+func getBastionIds(bastion model.BastionMachine) []string {
+	ids := []string{}
+
+	for _, candidate := range bastion.Candidates() {
+		ids = append(ids, candidate.InstanceId)
+	}
+
+	return ids
+}
+
+func getMachineNames(machines map[string]model.Machine) []string {
+	names := []string{}
+
+	for _, machine := range machines {
+		names = append(names, machine.Name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func assertStrings(t *testing.T, expected []string, actual []string) {
+	t.Helper()
+
+	if len(expected) != len(actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Fatalf("Expected %v, got %v", expected, actual)
+		}
+	}
+}
+
+func TestFindBastionInVpcRanking(t *testing.T) {
+	fixture := testFixture{
+		Regions: map[string][]types.Instance{
+			testRegion: {
+				makeTestInstance("i-db", "db", "vpc-1", "us-east-1a", "", types.InstanceStateNameRunning),
+				makeTestInstance("i-stopped", "bastion-a", "vpc-1", "us-east-1a", "1.2.3.1", types.InstanceStateNameStopped),
+				makeTestInstance("i-other-zone", "bastion-b", "vpc-1", "us-east-1b", "1.2.3.2", types.InstanceStateNameRunning),
+				makeTestInstance("i-d", "bastion-d", "vpc-1", "us-east-1a", "1.2.3.4", types.InstanceStateNameRunning),
+				makeTestInstance("i-c", "bastion-c", "vpc-1", "us-east-1a", "1.2.3.3", types.InstanceStateNameRunning),
+				makeTestInstance("i-web", "web", "vpc-1", "us-east-1a", "1.2.3.5", types.InstanceStateNameRunning),
+				makeTestInstance("i-elsewhere", "bastion-e", "vpc-2", "us-east-1a", "1.2.3.6", types.InstanceStateNameRunning),
+			},
+		},
+	}
+
+	machines := loadTestMachines(t, fixture, makeTestConfig())
+	bastions := machines["i-db"].Bastions
+
+	if len(bastions) != 1 {
+		t.Fatalf("Expected a single bastion hop, got %v", bastions)
+	}
+
+	// Running before stopped, same zone before another zone, then by name
+	//
+	assertStrings(t, []string{"i-c", "i-d", "i-other-zone", "i-stopped"}, getBastionIds(bastions[0]))
+
+	if bastions[0].Url != "1.2.3.3" || bastions[0].User != "ubuntu" || bastions[0].Keyfile != "keys/main.pem" {
+		t.Errorf("Unexpected bastion %+v", bastions[0])
+	}
+}
+
+func TestFindBastionInVpcRanks(t *testing.T) {
+	instances := []types.Instance{
+		makeTestInstance("i-app", "app", "vpc-app", "us-east-1a", "", types.InstanceStateNameRunning),
+		makeTestInstance("i-peer", "bastion-peer", "vpc-peer", "us-east-1a", "1.2.3.1", types.InstanceStateNameRunning),
+		makeTestInstance("i-shared", "bastion-shared", "vpc-shared", "us-east-1b", "1.2.3.2", types.InstanceStateNameRunning),
+		makeTestInstance("i-unrelated", "bastion-unrelated", "vpc-unrelated", "us-east-1a", "1.2.3.3", types.InstanceStateNameRunning),
+	}
+
+	peerings := map[string][]types.VpcPeeringConnection{
+		testRegion: {{
+			VpcPeeringConnectionId: aws.String("pcx-1"),
+			Status:                 &types.VpcPeeringConnectionStateReason{Code: types.VpcPeeringConnectionStateReasonCodeActive},
+			RequesterVpcInfo:       &types.VpcPeeringConnectionVpcInfo{VpcId: aws.String("vpc-peer")},
+			AccepterVpcInfo:        &types.VpcPeeringConnectionVpcInfo{VpcId: aws.String("vpc-app")},
+		}},
+	}
+
+	tests := []struct {
+		name          string
+		instances     []types.Instance
+		bastionVpcs   []model.BastionVpc
+		connectedVpcs bool
+		expected      []string
+	}{
+		{
+			name:      "no mapped or connected vpc",
+			instances: instances,
+		},
+		{
+			name:          "connected vpc",
+			instances:     instances,
+			connectedVpcs: true,
+			expected:      []string{"i-peer"},
+		},
+		{
+			name:          "mapped vpc before connected vpc",
+			instances:     instances,
+			bastionVpcs:   []model.BastionVpc{{VpcId: "vpc-app", BastionVpcId: "vpc-shared"}},
+			connectedVpcs: true,
+			expected:      []string{"i-shared", "i-peer"},
+		},
+		{
+			name:          "same vpc first",
+			instances:     append(instances, makeTestInstance("i-same", "bastion-same", "vpc-app", "us-east-1b", "1.2.3.4", types.InstanceStateNameRunning)),
+			bastionVpcs:   []model.BastionVpc{{VpcId: "vpc-app", BastionVpcId: "vpc-shared"}},
+			connectedVpcs: true,
+			expected:      []string{"i-same", "i-shared", "i-peer"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := makeTestConfig()
+			config.BastionVpcs = test.bastionVpcs
+			config.ConnectedVpcs = test.connectedVpcs
+
+			fixture := testFixture{
+				Regions:     map[string][]types.Instance{testRegion: test.instances},
+				VpcPeerings: peerings,
+			}
+
+			bastions := loadTestMachines(t, fixture, config)["i-app"].Bastions
+
+			if len(test.expected) == 0 {
+				if len(bastions) != 0 {
+					t.Fatalf("Expected no bastion, got %v", bastions)
+				}
+
+				return
+			}
+
+			if len(bastions) != 1 {
+				t.Fatalf("Expected a single bastion hop, got %v", bastions)
+			}
+
+			assertStrings(t, test.expected, getBastionIds(bastions[0]))
+		})
+	}
+}
+
+func TestIncludeExcludeRules(t *testing.T) {
+	team := types.Tag{Key: aws.String("Team"), Value: aws.String("data")}
+	db := makeTestInstance("i-db-1", "db-1", "vpc-1", "us-east-1a", "1.2.3.1", types.InstanceStateNameRunning)
+	db.Tags = append(db.Tags, team)
+
+	fixture := testFixture{
+		Regions: map[string][]types.Instance{
+			testRegion: {
+				makeTestInstance("i-web-1", "web-1", "vpc-1", "us-east-1a", "1.2.3.2", types.InstanceStateNameRunning),
+				makeTestInstance("i-web-22", "web-22", "vpc-1", "us-east-1a", "1.2.3.3", types.InstanceStateNameRunning),
+				makeTestInstance("i-web-test", "web-test", "vpc-1", "us-east-1a", "1.2.3.4", types.InstanceStateNameRunning),
+				makeTestInstance("i-cache-1", "cache-1", "vpc-1", "us-east-1a", "1.2.3.5", types.InstanceStateNameRunning),
+				db,
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		include  string
+		exclude  string
+		expected []string
+	}{
+		{
+			name:     "no rules",
+			expected: []string{"cache-1", "db-1", "web-1", "web-22", "web-test"},
+		},
+		{
+			name:     "include by name and tag",
+			include:  "web-*,tag:Team=data",
+			expected: []string{"db-1", "web-1", "web-22", "web-test"},
+		},
+		{
+			name:     "exclude wins over include",
+			include:  "web-*",
+			exclude:  "/-test$/",
+			expected: []string{"web-1", "web-22"},
+		},
+		{
+			name:     "regexp with a comma",
+			include:  "/^web-[0-9]{1,2}$/,cache-*",
+			expected: []string{"cache-1", "web-1", "web-22"},
+		},
+		{
+			name:     "exclude by tag without a pattern",
+			exclude:  "tag:Team",
+			expected: []string{"cache-1", "web-1", "web-22", "web-test"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := makeTestConfig()
+			config.IncludeRules = model.MakeMachineRules(test.include)
+			config.ExcludeRules = model.MakeMachineRules(test.exclude)
+
+			assertStrings(t, test.expected, getMachineNames(loadTestMachines(t, fixture, config)))
+		})
+	}
+}
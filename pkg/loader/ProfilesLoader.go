@@ -0,0 +1,69 @@
+package loader
+
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/inventory"
+	"aws-bassh/pkg/model"
+	"context"
+	"log"
+	"sync"
+)
+
+type ProfileMachines struct {
+	Config   model.GenerateConfig
+	Machines map[string]model.Machine
+	Err      error
+}
+
+func LoadAllProfiles(ctx context.Context, inventory *inventory.Inventory, config model.GenerateConfig) ([]ProfileMachines, error) {
+	profiles, err := resolveProfiles(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Loading machines from %v profiles %v", len(profiles), profiles)
+
+	results := make([]ProfileMachines, len(profiles))
+	amiDistros := openAmiDistros(config)
+
+	var wg sync.WaitGroup
+
+	for i, profile := range profiles {
+		wg.Add(1)
+
+		go func(i int, profile string) {
+			defer wg.Done()
+
+			profileConfig := config.ForProfile(profile)
+			machines, err := loadProfileMachines(ctx, inventory, amiDistros, profileConfig)
+			results[i] = ProfileMachines{
+				Config:   profileConfig,
+				Machines: machines,
+				Err:      err,
+			}
+		}(i, profile)
+	}
+
+	wg.Wait()
+	return results, nil
+}
+
+func resolveProfiles(config model.GenerateConfig) ([]string, error) {
+	if config.AllProfiles {
+		return ec2client.ListProfiles()
+	}
+
+	return config.AwsProfiles, nil
+}
+
+func loadProfileMachines(ctx context.Context, inventory *inventory.Inventory, amiDistros *amiDistros, config model.GenerateConfig) (map[string]model.Machine, error) {
+	session, err := ec2client.Initialize(ctx, config.AwsProfile, config.AwsOptions)
+
+	if err != nil {
+		log.Printf("Error initializing profile %v, %v", config.AwsProfile, err)
+		return nil, err
+	}
+
+	return loadAllMachines(session, inventory, amiDistros, config)
+}
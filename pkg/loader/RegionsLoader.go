@@ -0,0 +1,79 @@
+package loader
+
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/inventory"
+	"aws-bassh/pkg/model"
+	"log"
+	"sync"
+)
+
+type regionMachines struct {
+	region   string
+	machines map[string]model.Machine
+	err      error
+}
+
+func loadAccountMachines(session *ec2client.Session, inventory *inventory.Inventory, amiDistros *amiDistros, config model.GenerateConfig) (map[string]model.Machine, error) {
+	regions, err := resolveRegions(session, config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Route 53 is global, the records are shared by the regions
+	//
+	dnsRecords := resolveDnsRecords(session, config)
+	results := loadRegionsConcurrently(session, inventory, amiDistros, regions, dnsRecords, config)
+	machines := make(map[string]model.Machine)
+
+	for _, result := range results {
+		if result.err != nil {
+			return nil, result.err
+		}
+
+		log.Printf("Loaded %v machines from profile %v, account %v, region %v", len(result.machines), session.Profile, session.AccountId, result.region)
+
+		for id, machine := range result.machines {
+			machines[id] = machine
+		}
+	}
+
+	return machines, nil
+}
+
+func resolveRegions(session *ec2client.Session, config model.GenerateConfig) ([]string, error) {
+	if len(config.Regions) == 0 {
+		return []string{session.DefaultRegion()}, nil
+	}
+
+	if len(config.Regions) == 1 && config.Regions[0] == model.AllRegions {
+		return session.DescribeRegions()
+	}
+
+	return config.Regions, nil
+}
+
+func loadRegionsConcurrently(session *ec2client.Session, inventory *inventory.Inventory, amiDistros *amiDistros, regions []string, dnsRecords map[string][]ec2client.DnsRecord, config model.GenerateConfig) []regionMachines {
+	results := make([]regionMachines, len(regions))
+
+	var wg sync.WaitGroup
+
+	for i, region := range regions {
+		wg.Add(1)
+
+		go func(i int, region string) {
+			defer wg.Done()
+
+			machines, err := loadRegionMachines(session, inventory, amiDistros, region, dnsRecords, config)
+			results[i] = regionMachines{
+				region:   region,
+				machines: machines,
+				err:      err,
+			}
+		}(i, region)
+	}
+
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,80 @@
+package loader
+
+import (
+	"aws-bassh/pkg/ec2client"
+	"aws-bassh/pkg/model"
+	"log"
+)
+
+const (
+	sameVpcRank = iota
+	mappedVpcRank
+	connectedVpcRank
+)
+
+// Maps a VPC to the other VPCs its implicit bastion may come from, with their rank, the explicit
+// --bastion-vpcs come before the VPCs connected by peering or transit gateway
+func resolveBastionVpcs(session *ec2client.Session, region string, config model.GenerateConfig) map[string]map[string]int {
+	bastionVpcs := make(map[string]map[string]int)
+
+	for _, bastionVpc := range config.BastionVpcs {
+		addBastionVpc(bastionVpcs, bastionVpc.VpcId, bastionVpc.BastionVpcId, mappedVpcRank)
+	}
+
+	if !config.ConnectedVpcs {
+		return bastionVpcs
+	}
+
+	for _, connection := range describeVpcConnections(session, region) {
+		addBastionVpc(bastionVpcs, connection.VpcId, connection.PeerVpcId, connectedVpcRank)
+		addBastionVpc(bastionVpcs, connection.PeerVpcId, connection.VpcId, connectedVpcRank)
+	}
+
+	return bastionVpcs
+}
+
+// Failing to describe the connections isn't fatal, e.g. a missing permission, the bastions are
+// looked up in the mapped VPCs only
+func describeVpcConnections(session *ec2client.Session, region string) []ec2client.VpcConnection {
+	connections := []ec2client.VpcConnection{}
+
+	peerings, err := session.DescribeVpcPeerings(region)
+
+	if err == nil {
+		connections = append(connections, peerings...)
+	}
+
+	gatewayVpcs, err := session.DescribeTransitGatewayVpcs(region)
+
+	if err == nil {
+		connections = append(connections, gatewayVpcs...)
+	}
+
+	log.Printf("Found %v connected VPC pairs, profile %v, region %v", len(connections), session.Profile, region)
+	return connections
+}
+
+func addBastionVpc(bastionVpcs map[string]map[string]int, vpcId string, bastionVpcId string, rank int) {
+	if vpcId == "" || bastionVpcId == "" || vpcId == bastionVpcId {
+		return
+	}
+
+	if bastionVpcs[vpcId] == nil {
+		bastionVpcs[vpcId] = make(map[string]int)
+	}
+
+	if currentRank, found := bastionVpcs[vpcId][bastionVpcId]; found && currentRank <= rank {
+		return
+	}
+
+	bastionVpcs[vpcId][bastionVpcId] = rank
+}
+
+func getBastionVpcRank(vpcId string, candidateVpcId string, context buildModelContext) (int, bool) {
+	if vpcId == candidateVpcId {
+		return sameVpcRank, true
+	}
+
+	rank, found := context.bastionVpcs[vpcId][candidateVpcId]
+	return rank, found
+}
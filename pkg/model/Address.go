@@ -0,0 +1,42 @@
+package model
+
+import (
+	"log"
+	"strings"
+)
+
+// The address used to connect a machine, by default its Route 53 name, otherwise the public IP, or the
+// private IP when connecting through a bastion. eni: selects the private IP of a network interface, by id or by device index,
+// e.g. eni:1 or eni:eni-0123456789abcdef0
+const (
+	AutoAddress       = ""
+	PublicIpAddress   = "public-ip"
+	ElasticIpAddress  = "elastic-ip"
+	PublicDnsAddress  = "public-dns"
+	PrivateIpAddress  = "private-ip"
+	PrivateDnsAddress = "private-dns"
+	Ipv6Address       = "ipv6"
+	DnsAddress        = "dns"
+	EniAddressPrefix  = "eni:"
+)
+
+var addressStrategies = []string{PublicIpAddress, ElasticIpAddress, PublicDnsAddress, PrivateIpAddress, PrivateDnsAddress, Ipv6Address, DnsAddress}
+
+func IsValidAddress(address string) bool {
+	if address == AutoAddress {
+		return true
+	}
+
+	if strings.HasPrefix(address, EniAddressPrefix) && len(address) > len(EniAddressPrefix) {
+		return true
+	}
+
+	for _, strategy := range addressStrategies {
+		if address == strategy {
+			return true
+		}
+	}
+
+	log.Printf("Invalid address %v, expected one of %v or %v<device index or eni id>", address, strings.Join(addressStrategies, ", "), EniAddressPrefix)
+	return false
+}
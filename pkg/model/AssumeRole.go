@@ -0,0 +1,57 @@
+package model
+
+import (
+	"strings"
+)
+
+const DefaultRoleSessionName = "awsbassh"
+
+// The fields of a role ARN, arn:partition:iam::account:role/name, an external ID may follow them
+const roleArnFields = 6
+
+var NoRole = AssumeRole{}
+
+type AssumeRole struct {
+	RoleArn     string
+	ExternalId  string
+	SessionName string
+}
+
+// Each role is an ARN optionally followed by its own external ID, e.g. arn:aws:iam::111111111111:role/Ec2Read:ext-1,
+// roles without one use the default external ID. Empty entries, e.g. of a trailing comma, are skipped
+func makeAssumeRoles(roleArns string, externalId string, sessionName string) []AssumeRole {
+	roles := []AssumeRole{}
+
+	for _, entry := range splitList(roleArns) {
+		roleArn, roleExternalId := splitRoleExternalId(entry, externalId)
+		roles = append(roles, MakeAssumeRole(roleArn, roleExternalId, sessionName))
+	}
+
+	return roles
+}
+
+func splitRoleExternalId(entry string, defaultExternalId string) (string, string) {
+	fields := strings.SplitN(entry, ":", roleArnFields+1)
+
+	if len(fields) <= roleArnFields || fields[roleArnFields] == "" {
+		return strings.TrimSuffix(entry, ":"), defaultExternalId
+	}
+
+	return strings.Join(fields[:roleArnFields], ":"), fields[roleArnFields]
+}
+
+func MakeAssumeRole(roleArn string, externalId string, sessionName string) AssumeRole {
+	if roleArn == "" {
+		return NoRole
+	}
+
+	if sessionName == "" {
+		sessionName = DefaultRoleSessionName
+	}
+
+	return AssumeRole{
+		RoleArn:     roleArn,
+		ExternalId:  externalId,
+		SessionName: sessionName,
+	}
+}
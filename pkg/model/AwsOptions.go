@@ -0,0 +1,113 @@
+package model
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const EndpointUrlEnv = "AWSBASSH_ENDPOINT_URL"
+
+type AwsOptions struct {
+	Ec2Fixture           string
+	EndpointUrl          string
+	SkipCredentialsCheck bool
+	Timeout              time.Duration
+	MaxAttempts          int
+	MaxBackoff           time.Duration
+	SsoLogin             bool
+}
+
+type awsOptionsParams struct {
+	ec2Fixture           *string
+	endpointUrl          *string
+	skipCredentialsCheck *bool
+	timeout              *time.Duration
+	maxAttempts          *int
+	maxBackoff           *time.Duration
+	ssoLogin             *bool
+}
+
+func addAwsOptionsParams(cmd *flag.FlagSet) awsOptionsParams {
+	return awsOptionsParams{
+		ec2Fixture:           cmd.String("ec2-fixture", "", "A JSON fixture of instances to use instead of the EC2 API (offline mode)"),
+		endpointUrl:          cmd.String("endpoint-url", "", "Custom AWS endpoint, e.g. http://localhost:4566 for LocalStack (default $"+EndpointUrlEnv+")"),
+		skipCredentialsCheck: cmd.Bool("skip-credentials-check", false, "Don't verify the credentials on startup, useful for emulators"),
+		timeout:              cmd.Duration("aws-timeout", 30*time.Second, "Timeout of a single AWS call, including its retries"),
+		maxAttempts:          cmd.Int("aws-max-attempts", 5, "Maximum attempts of an AWS call, on throttling and transient errors"),
+		maxBackoff:           cmd.Duration("aws-max-backoff", 20*time.Second, "Maximum backoff between AWS call attempts"),
+		ssoLogin:             cmd.Bool("sso-login", false, "Run 'aws sso login' when the SSO session has expired, and retry"),
+	}
+}
+
+func (params awsOptionsParams) makeAwsOptions() AwsOptions {
+	return AwsOptions{
+		Ec2Fixture:           getAbsolutePath(*params.ec2Fixture),
+		EndpointUrl:          getEndpointUrl(*params.endpointUrl),
+		SkipCredentialsCheck: *params.skipCredentialsCheck,
+		Timeout:              *params.timeout,
+		MaxAttempts:          *params.maxAttempts,
+		MaxBackoff:           *params.maxBackoff,
+		SsoLogin:             *params.ssoLogin,
+	}
+}
+
+// The options as arguments of a subcommand run by awsbassh itself, --sso-login is left out since the
+// subcommand has no terminal
+func (options AwsOptions) CommandLineArgs() []string {
+	args := []string{
+		"--aws-timeout", options.Timeout.String(),
+		"--aws-max-attempts", strconv.Itoa(options.MaxAttempts),
+		"--aws-max-backoff", options.MaxBackoff.String(),
+	}
+
+	if options.Ec2Fixture != "" {
+		args = append(args, "--ec2-fixture", options.Ec2Fixture)
+	}
+
+	if options.EndpointUrl != "" {
+		args = append(args, "--endpoint-url", options.EndpointUrl)
+	}
+
+	if options.SkipCredentialsCheck {
+		args = append(args, "--skip-credentials-check")
+	}
+
+	return args
+}
+
+func getEndpointUrl(endpointUrl string) string {
+	if endpointUrl != "" {
+		return endpointUrl
+	}
+
+	return os.Getenv(EndpointUrlEnv)
+}
+
+func getAbsolutePath(file string) string {
+	if file == "" {
+		return ""
+	}
+
+	path, err := filepath.Abs(file)
+
+	if err != nil {
+		log.Printf("Error getting absolute path of %v", file)
+		return file
+	}
+
+	return path
+}
+
+func getAbsolutePaths(files []string) []string {
+	paths := []string{}
+
+	for _, file := range files {
+		paths = append(paths, getAbsolutePath(file))
+	}
+
+	return paths
+}
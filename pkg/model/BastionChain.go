@@ -0,0 +1,107 @@
+package model
+
+import (
+	"log"
+	"strings"
+)
+
+// Builds an ordered chain of hops, the first hop is connected first. A host may include its user,
+// e.g. alice@jump.example.com, and candidates separated by |, e.g. jump1|jump2, which are tried in
+// order. Users and keys are matched to the hosts by position and the last one is used for the
+// remaining hosts
+func MakeBastionChain(hosts []string, users []string, keyfiles []string) []BastionMachine {
+	chain := []BastionMachine{}
+
+	for i, host := range hosts {
+		if host == "" {
+			continue
+		}
+
+		bastion := BastionMachine{}
+
+		for _, candidate := range strings.Split(host, "|") {
+			candidateBastion := makeBastionMachine(strings.TrimSpace(candidate), getNthOrLast(users, i), getNthOrLast(keyfiles, i))
+
+			if bastion.IsEmpty() {
+				bastion = candidateBastion
+			} else {
+				bastion.Fallbacks = append(bastion.Fallbacks, candidateBastion)
+			}
+		}
+
+		if !bastion.IsEmpty() {
+			chain = append(chain, bastion)
+		}
+	}
+
+	return chain
+}
+
+func makeBastionMachine(host string, user string, keyfile string) BastionMachine {
+	bastion := BastionMachine{
+		Url:     host,
+		User:    user,
+		Keyfile: keyfile,
+	}
+
+	if hostUser, url, found := strings.Cut(host, "@"); found {
+		bastion.User = hostUser
+		bastion.Url = url
+	}
+
+	return bastion
+}
+
+func getNthOrLast(values []string, n int) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	if n < len(values) {
+		return values[n]
+	}
+
+	return values[len(values)-1]
+}
+
+func splitList(list string) []string {
+	values := []string{}
+
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// An explicit mapping of a VPC to a VPC hosting its bastions, e.g. vpc-app=vpc-shared
+type BastionVpc struct {
+	VpcId        string
+	BastionVpcId string
+}
+
+func makeBastionVpcs(mappings string) []BastionVpc {
+	bastionVpcs := []BastionVpc{}
+
+	for _, mapping := range splitList(mappings) {
+		vpcId, bastionVpcId, _ := strings.Cut(mapping, "=")
+
+		bastionVpcs = append(bastionVpcs, BastionVpc{
+			VpcId:        strings.TrimSpace(vpcId),
+			BastionVpcId: strings.TrimSpace(bastionVpcId),
+		})
+	}
+
+	return bastionVpcs
+}
+
+func (bastionVpc BastionVpc) Valid() bool {
+	if bastionVpc.VpcId == "" || bastionVpc.BastionVpcId == "" {
+		log.Printf("Invalid bastion VPC mapping %v=%v, expected vpc=bastion-vpc", bastionVpc.VpcId, bastionVpc.BastionVpcId)
+		return false
+	}
+
+	return true
+}
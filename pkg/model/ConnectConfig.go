@@ -4,6 +4,7 @@ import (
 	"flag"
 	"os"
 	"strings"
+	"time"
 )
 
 var (
@@ -13,10 +14,28 @@ var (
 	machineDataParam       = connectCmd.String("machine-data", "", "Base64 serialized machine information")
 	forceBastionParam      = connectCmd.Bool("force-bastion", false, "Force connection via bastion, even if Public Ip available")
 	usePublicDnsParam      = connectCmd.Bool("use-public-dns", false, "Use public dns instead of public ip")
+	addressParam           = connectCmd.String("address", "", "The machine address to use: public-ip, elastic-ip, public-dns, private-ip, private-dns, ipv6, dns or eni:<device index or eni id> (default is the address tag, or the Route 53 name, or the public ip)")
 	sshParamsParam         = connectCmd.String("ssh-params", "-o StrictHostKeyChecking=no -q", "Extra ssh parameters")
 	sshCommandsParam       = connectCmd.String("ssh-commands", "", "SSH Commands to run after the ssh connection is established")
 	sshUserNameParam       = connectCmd.String("ssh-user", "", "Use this ssh user for connection")
 	sftpParam              = connectCmd.Bool("sftp", false, "Connect sftp instead of ssh")
+	roleArnConnectParam    = connectCmd.String("role-arn", "", "IAM role ARN to assume, overrides the role stored in the machine data")
+	externalIdConnectParam = connectCmd.String("external-id", "", "External ID to use when assuming the role")
+	roleSessionNameParam   = connectCmd.String("role-session-name", DefaultRoleSessionName, "Session name to use when assuming the role")
+	inventoryFileParam     = connectCmd.String("inventory-file", defaultCacheFile("inventory.json"), "A cache of the instances written by generate (empty to disable)")
+	inventoryTtlParam      = connectCmd.Duration("inventory-ttl", 10*time.Minute, "Use the cached instance if it is fresher than this")
+	refreshParam           = connectCmd.Bool("refresh", false, "Ignore the inventory cache and lookup the instance")
+	startIfStoppedParam    = connectCmd.Bool("start-if-stopped", false, "Start the machine if it is stopped, and wait for it to run")
+	startTimeoutParam      = connectCmd.Duration("start-timeout", 5*time.Minute, "Maximum time to wait for a started machine to run")
+	yesParam               = connectCmd.Bool("yes", false, "Don't ask for confirmation")
+	viaParam               = connectCmd.String("via", "", "A comma separated chain of [user@]host bastions to connect through, overrides the machine bastions")
+	viaKeysParam           = connectCmd.String("via-keys", "", "A comma separated list of ssh private keys for the --via bastions")
+	probeTimeoutParam      = connectCmd.Duration("bastion-probe-timeout", 3*time.Second, "Timeout of the TCP check of the bastion candidates, and of the fallback connections (0 to disable)")
+	ssmParam               = connectCmd.Bool("ssm", false, "Connect with an SSM Session Manager session instead of ssh (default is the connect mode tag of the machine)")
+	useEndpointParam       = connectCmd.Bool("eice", false, "Connect through the EC2 Instance Connect Endpoint of the machine, even if it has a public IP or bastions")
+	instanceConnectParam   = connectCmd.Bool("instance-connect", false, "Push an ephemeral ssh key with EC2 Instance Connect to the machine and its bastions, instead of their pem keys")
+	ephemeralKeyParam      = connectCmd.String("instance-connect-key", defaultCacheFile("instance-connect/id_ed25519"), "The ephemeral ssh key of --instance-connect, generated when missing")
+	awsOptionsConnectParam = addAwsOptionsParams(connectCmd)
 )
 
 type ConnectConfig struct {
@@ -24,24 +43,58 @@ type ConnectConfig struct {
 	Machine        Machine
 	ForceBastion   bool
 	UsePublicDns   bool
+	Address        string
 	ExtraSSHParams []string
 	SSHCommands    []string
 	SSHUserName    string
 	Sftp           bool
+	AssumeRole     AssumeRole
+	AwsOptions     AwsOptions
+	InventoryFile  string
+	InventoryTtl   time.Duration
+	Refresh        bool
+	StartIfStopped bool
+	StartTimeout   time.Duration
+	Yes            bool
+	Via            []BastionMachine
+	ProbeTimeout   time.Duration
+	UseEndpoint    bool
+	Ssm            bool
+
+	InstanceConnect    bool
+	InstanceConnectKey string
 }
 
 func MakeCommandLineConnectConfig() ConnectConfig {
 	connectCmd.Parse(os.Args[2:])
+	machine := getMachine(*machineDataParam)
+	awsOptions := awsOptionsConnectParam.makeAwsOptions()
 
 	return ConnectConfig{
 		AwsProfile:     getAwsConnectProfile(),
-		Machine:        getMachine(*machineDataParam),
+		Machine:        machine,
 		ForceBastion:   *forceBastionParam,
 		UsePublicDns:   *usePublicDnsParam,
+		Address:        *addressParam,
 		ExtraSSHParams: strings.Split(*sshParamsParam, " "),
 		SSHUserName:    *sshUserNameParam,
 		SSHCommands:    strings.Split(*sshCommandsParam, " "),
 		Sftp:           *sftpParam,
+		AssumeRole:     MakeAssumeRole(*roleArnConnectParam, *externalIdConnectParam, *roleSessionNameParam),
+		AwsOptions:     awsOptions,
+		InventoryFile:  getCacheFile(connectCmd, "inventory-file", *inventoryFileParam, awsOptions),
+		InventoryTtl:   *inventoryTtlParam,
+		Refresh:        *refreshParam,
+		StartIfStopped: *startIfStoppedParam,
+		StartTimeout:   *startTimeoutParam,
+		Yes:            *yesParam,
+		Via:            MakeBastionChain(splitList(*viaParam), nil, getAbsolutePaths(splitList(*viaKeysParam))),
+		ProbeTimeout:   *probeTimeoutParam,
+		UseEndpoint:    *useEndpointParam,
+		Ssm:            getSsm(machine),
+
+		InstanceConnect:    *instanceConnectParam,
+		InstanceConnectKey: getAbsolutePath(*ephemeralKeyParam),
 	}
 }
 
@@ -62,12 +115,25 @@ func getAwsConnectProfile() string {
 	return ""
 }
 
+// --ssm is stronger than the connect mode tag of the machine, e.g. --ssm=false connects a tagged machine with ssh
+func getSsm(machine Machine) bool {
+	ssm := machine.Ssm
+
+	connectCmd.Visit(func(f *flag.Flag) {
+		if f.Name == "ssm" {
+			ssm = *ssmParam
+		}
+	})
+
+	return ssm
+}
+
 func getMachine(serializedMachine string) Machine {
-	if *machineDataParam == "" {
+	if serializedMachine == "" {
 		return NoMachine
 	}
 
-	machine, err := DeserializeMachine(*machineDataParam)
+	machine, err := DeserializeMachine(serializedMachine)
 
 	if err != nil {
 		return NoMachine
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path"
+)
+
+func defaultCacheFile(name string) string {
+	cacheDir, err := os.UserCacheDir()
+
+	if err != nil {
+		log.Printf("Error getting user cache directory, %v is disabled %v", name, err)
+		return ""
+	}
+
+	return path.Join(cacheDir, "awsbassh", name)
+}
+
+func defaultConfigFile(name string) string {
+	configDir, err := os.UserConfigDir()
+
+	if err != nil {
+		log.Printf("Error getting user config directory, %v is disabled %v", name, err)
+		return ""
+	}
+
+	return path.Join(configDir, "awsbassh", name)
+}
+
+// Fixture instances and images are not mixed with the real ones, a fixture run uses a cache file only when it is explicit
+func getCacheFile(cmd *flag.FlagSet, name string, file string, options AwsOptions) string {
+	if options.Ec2Fixture == "" || isFlagPassed(cmd, name) {
+		return file
+	}
+
+	return ""
+}
+
+func isFlagPassed(cmd *flag.FlagSet, name string) bool {
+	passed := false
+
+	cmd.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+
+	return passed
+}
@@ -3,52 +3,151 @@ package model
 import (
 	"flag"
 	"os"
+	"path"
 	"strings"
 )
 
 var (
 	generateCmd = flag.NewFlagSet("generate", flag.ExitOnError)
 
-	awsProfileGenerateParam   = generateCmd.String("profile", "", "AWS Cli Profile to use")
-	outputFileParam           = generateCmd.String("output-file", "output.sh", "Bash output file")
+	awsProfileGenerateParam   = generateCmd.String("profile", "", "AWS Cli Profile to use, or a comma separated list of profiles")
+	allProfilesParam          = generateCmd.Bool("all-profiles", false, "Generate for every profile in the aws config file")
+	outputFileParam           = generateCmd.String("output-file", "output.sh", "Bash output file (the index file when generating for several profiles)")
+	profilesOutputDirParam    = generateCmd.String("profiles-output-dir", ".output", "A directory for the per profile bash files, when generating for several profiles")
 	bashFunctionsPrefixParam  = generateCmd.String("prefix", "ec2_", "Bash functions prefix")
 	keysDirectoryParam        = generateCmd.String("keys", "keys", "A directory containing pem keys for the machines")
 	forceBastionGenerateParam = generateCmd.Bool("force-bastion", false, "Force connection via bastion, even if Public Ip available")
 	nameTagsParam             = generateCmd.String("name-tags", "Name", "A comma separated names of tags, for Machine name")
+	nameTemplateParam         = generateCmd.String("name-template", "", "A Go template of the machine name, e.g. '{{ .Tags.Env }}-{{ .Name }}' (default is the first name tag)")
 	userTagsParam             = generateCmd.String("user-tags", "SSHUser", "A comma separated names of tags, for SSH user")
+	keyTagsParam              = generateCmd.String("key-tags", "", "A comma separated names of tags, for SSH key name, instead of the instance key pair (opt-in, e.g. SSHKey)")
+	addressTagsParam          = generateCmd.String("address-tags", "SSHAddress", "A comma separated names of tags, for the address connect uses, e.g. private-ip or ipv6")
+	connectModeTagsParam      = generateCmd.String("connect-mode-tags", "ConnectMode", "A comma separated names of tags, for the connect mode, ssm connects with SSM Session Manager")
 	bastionUrlTagsParam       = generateCmd.String("bastion-url-tags", "BastionUrl", "A comma separated names of tags, for Bastion url")
 	bastionUserTagsParam      = generateCmd.String("bastion-user-tags", "BastionUser", "A comma separated names of tags, for Bastion user")
 	bastionKeysTagsParam      = generateCmd.String("bastion-key-tags", "BastionKey", "A comma separated names of tags, for Bastion ssh key")
+	bastionInstanceTagsParam  = generateCmd.String("bastion-instance-tags", "BastionInstanceId", "A comma separated names of tags, for Bastion instance id resolved on connect")
+	bastionNameTagsParam      = generateCmd.String("bastion-name-tags", "BastionName", "A comma separated names of tags, for Bastion Name tag resolved on connect")
+	bastionVpcsParam          = generateCmd.String("bastion-vpcs", "", "A comma separated list of vpc=bastion-vpc, for implicit bastions in another VPC, e.g. vpc-app=vpc-shared")
+	connectedVpcsParam        = generateCmd.Bool("connected-vpc-bastions", false, "Look for implicit bastions in VPCs connected by peering or transit gateway")
+	jumpHostsParam            = generateCmd.String("jump-hosts", "", "A comma separated chain of [user@]host jump hosts, prepended to the bastions of every machine")
+	jumpKeysParam             = generateCmd.String("jump-keys", "", "A comma separated names of the jump hosts ssh keys")
+	bastionRulesParam         = generateCmd.String("bastion-rules", "tag:Name=/(?i)bastion/", "A comma separated list of rules, for implicit bastions in the VPC, e.g. tag:Role=bastion")
+	roleArnsGenerateParam     = generateCmd.String("role-arns", "", "A comma separated list of IAM role ARNs to assume, for cross account discovery, each optionally followed by :external-id")
+	externalIdGenerateParam   = generateCmd.String("external-id", "", "External ID to use when assuming the roles which have none of their own")
+	sessionNameGenerateParam  = generateCmd.String("role-session-name", DefaultRoleSessionName, "Session name to use when assuming the roles")
+	organizationParam         = generateCmd.Bool("organization", false, "Load machines from every active account in the AWS organization")
+	roleNameParam             = generateCmd.String("role-name", "OrganizationAccountAccessRole", "The role name to assume in each organization account")
+	regionsParam              = generateCmd.String("regions", "", "A comma separated list of regions, or 'all' for every enabled region (default is the profile region)")
+	inventoryGenerateParam    = generateCmd.String("inventory-file", defaultCacheFile("inventory.json"), "A cache of the loaded instances, used by connect (empty to disable, disabled with --ec2-fixture unless set)")
+	amiCacheFileParam         = generateCmd.String("ami-cache-file", defaultCacheFile("ami-distros.json"), "A cache of the detected AMI distros (empty to disable, disabled with --ec2-fixture unless set)")
+	amiMappingFileParam       = generateCmd.String("ami-mapping-file", defaultConfigFile("ami-distros.conf"), "A user mapping of AMI ids or image name patterns to distros")
+	defaultDistroParam        = generateCmd.String("default-distro", "ubuntu", "The distro of machines whose AMI can't be detected, for the default SSH user")
+	includeStoppedParam       = generateCmd.Bool("include-stopped", false, "Generate functions for stopped machines as well, see connect --start-if-stopped")
+	filtersParam              = generateCmd.String("filters", "", "A comma separated list of EC2 API filters, e.g. tag:Env=prod,instance-type=t3.*")
+	includeParam              = generateCmd.String("include", "", "A comma separated list of rules, only machines matching one of them are generated, e.g. web-*,tag:Team=infra")
+	excludeParam              = generateCmd.String("exclude", "", "A comma separated list of rules, machines matching one of them are skipped, e.g. /-test$/")
+	route53ZonesParam         = generateCmd.String("route53-zones", "", "A comma separated list of Route 53 hosted zone ids, their A, AAAA and CNAME records name the machines for connect")
+	route53NamesParam         = generateCmd.Bool("route53-names", false, "Use the Route 53 name of a machine as its function name")
+	eiceParam                 = generateCmd.Bool("instance-connect-endpoints", false, "Record the EC2 Instance Connect Endpoint of each machine VPC, connect tunnels through it instead of a bastion")
+	awsOptionsGenerateParams  = addAwsOptionsParams(generateCmd)
 )
 
+const AllRegions = "all"
+
 type GenerateConfig struct {
 	AwsProfile      string
+	AwsProfiles     []string
+	AllProfiles     bool
 	OutputFile      string
+	ProfilesDir     string
 	KeysDirectory   string
 	BashAliasPrefix string
 	ForceBastion    bool
+	Regions         []string
+	AssumeRoles     []AssumeRole
+	Organization    bool
+	RoleName        string
+	RoleExternalId  string
+	RoleSessionName string
+	AwsOptions      AwsOptions
+	InventoryFile   string
+	AmiCacheFile    string
+	AmiMappingFile  string
+	DefaultDistro   string
+	IncludeStopped  bool
+	Ec2Filters      []Ec2Filter
+	IncludeRules    []MachineRule
+	ExcludeRules    []MachineRule
+	NameTemplate    NameTemplate
+	BastionRules    []MachineRule
+	BastionVpcs     []BastionVpc
+	ConnectedVpcs   bool
+	JumpHosts       []string
+	JumpKeys        []string
+	Route53Zones    []string
+	Route53Names    bool
+	EiceEndpoints   bool
 
 	NameTags           []string
 	UserTags           []string
+	KeyTags            []string
+	AddressTags        []string
+	ConnectModeTags    []string
 	BastionUrlTags     []string
 	BastionUserTags    []string
 	BastionKeyNameTags []string
+	BastionIdTags      []string
+	BastionNameTags    []string
 }
 
 func MakeCommandLineGenerateConfig() GenerateConfig {
 	generateCmd.Parse(os.Args[2:])
+	awsOptions := awsOptionsGenerateParams.makeAwsOptions()
 
 	return GenerateConfig{
 		AwsProfile:         getAwsGenerateProfile(),
+		AwsProfiles:        strings.Split(getAwsGenerateProfile(), ","),
+		AllProfiles:        *allProfilesParam,
 		OutputFile:         *outputFileParam,
+		ProfilesDir:        *profilesOutputDirParam,
 		KeysDirectory:      *keysDirectoryParam,
 		BashAliasPrefix:    *bashFunctionsPrefixParam,
 		ForceBastion:       *forceBastionGenerateParam,
+		Regions:            getRegions(),
+		AssumeRoles:        makeAssumeRoles(*roleArnsGenerateParam, *externalIdGenerateParam, *sessionNameGenerateParam),
+		Organization:       *organizationParam,
+		RoleName:           *roleNameParam,
+		RoleExternalId:     *externalIdGenerateParam,
+		RoleSessionName:    *sessionNameGenerateParam,
+		AwsOptions:         awsOptions,
+		InventoryFile:      getAbsolutePath(getCacheFile(generateCmd, "inventory-file", *inventoryGenerateParam, awsOptions)),
+		AmiCacheFile:       getAbsolutePath(getCacheFile(generateCmd, "ami-cache-file", *amiCacheFileParam, awsOptions)),
+		AmiMappingFile:     getAbsolutePath(*amiMappingFileParam),
+		DefaultDistro:      *defaultDistroParam,
+		IncludeStopped:     *includeStoppedParam,
+		Ec2Filters:         makeEc2Filters(*filtersParam),
+		IncludeRules:       MakeMachineRules(*includeParam),
+		ExcludeRules:       MakeMachineRules(*excludeParam),
+		NameTemplate:       makeNameTemplate(*nameTemplateParam),
+		BastionRules:       MakeMachineRules(*bastionRulesParam),
+		BastionVpcs:        makeBastionVpcs(*bastionVpcsParam),
+		ConnectedVpcs:      *connectedVpcsParam,
+		JumpHosts:          splitList(*jumpHostsParam),
+		JumpKeys:           splitList(*jumpKeysParam),
+		Route53Zones:       splitList(*route53ZonesParam),
+		Route53Names:       *route53NamesParam,
+		EiceEndpoints:      *eiceParam,
 		NameTags:           strings.Split(*nameTagsParam, ","),
 		UserTags:           strings.Split(*userTagsParam, ","),
+		KeyTags:            splitList(*keyTagsParam),
+		AddressTags:        strings.Split(*addressTagsParam, ","),
+		ConnectModeTags:    strings.Split(*connectModeTagsParam, ","),
 		BastionUrlTags:     strings.Split(*bastionUrlTagsParam, ","),
 		BastionUserTags:    strings.Split(*bastionUserTagsParam, ","),
 		BastionKeyNameTags: strings.Split(*bastionKeysTagsParam, ","),
+		BastionIdTags:      strings.Split(*bastionInstanceTagsParam, ","),
+		BastionNameTags:    strings.Split(*bastionNameTagsParam, ","),
 	}
 }
 
@@ -67,3 +166,47 @@ func getAwsGenerateProfile() string {
 
 	return ""
 }
+
+func getRegions() []string {
+	if *regionsParam == "" {
+		return nil
+	}
+
+	return strings.Split(*regionsParam, ",")
+}
+
+func (config GenerateConfig) Validate() bool {
+	valid := true
+
+	for _, filter := range config.Ec2Filters {
+		valid = filter.Valid() && valid
+	}
+
+	rules := append(append([]MachineRule{}, config.IncludeRules...), config.ExcludeRules...)
+
+	for _, rule := range append(rules, config.BastionRules...) {
+		valid = rule.Valid() && valid
+	}
+
+	for _, bastionVpc := range config.BastionVpcs {
+		valid = bastionVpc.Valid() && valid
+	}
+
+	return config.NameTemplate.Valid() && valid
+}
+
+func (config GenerateConfig) IsMultiProfile() bool {
+	return config.AllProfiles || len(config.AwsProfiles) > 1
+}
+
+// Each profile gets its own output file and functions prefix, e.g. .output/prod.sh with prod_ functions
+func (config GenerateConfig) ForProfile(profile string) GenerateConfig {
+	profileConfig := config
+	profileConfig.AwsProfile = profile
+	profileConfig.AwsProfiles = []string{profile}
+	profileConfig.AllProfiles = false
+	profileConfig.OutputFile = path.Join(config.ProfilesDir, profile+".sh")
+	profileConfig.BashAliasPrefix = profile + "_"
+
+	return profileConfig
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"flag"
+	"os"
+	"time"
+)
+
+const (
+	StartAction  = "start"
+	StopAction   = "stop"
+	RebootAction = "reboot"
+	StatusAction = "status"
+)
+
+// The start, stop, reboot and status subcommands share their flags, the flag set is named by the action on parse
+var (
+	lifecycleCmd = flag.NewFlagSet("lifecycle", flag.ExitOnError)
+
+	awsProfileLifecycleParam      = lifecycleCmd.String("profile", "", "AWS Cli Profile to use")
+	machineDataLifecycleParam     = lifecycleCmd.String("machine-data", "", "Base64 serialized machine information")
+	roleArnLifecycleParam         = lifecycleCmd.String("role-arn", "", "IAM role ARN to assume, overrides the role stored in the machine data")
+	externalIdLifecycleParam      = lifecycleCmd.String("external-id", "", "External ID to use when assuming the role")
+	roleSessionNameLifecycleParam = lifecycleCmd.String("role-session-name", DefaultRoleSessionName, "Session name to use when assuming the role")
+	inventoryLifecycleParam       = lifecycleCmd.String("inventory-file", defaultCacheFile("inventory.json"), "A cache of the instances, updated with the new state (empty to disable)")
+	timeoutLifecycleParam         = lifecycleCmd.Duration("timeout", 5*time.Minute, "Maximum time to wait for the machine to reach the target state")
+	noWaitParam                   = lifecycleCmd.Bool("no-wait", false, "Don't wait for the machine to reach the target state")
+	yesLifecycleParam             = lifecycleCmd.Bool("yes", false, "Don't ask for confirmation")
+	awsOptionsLifecycleParam      = addAwsOptionsParams(lifecycleCmd)
+)
+
+type LifecycleConfig struct {
+	Action        string
+	AwsProfile    string
+	Machine       Machine
+	AssumeRole    AssumeRole
+	AwsOptions    AwsOptions
+	InventoryFile string
+	Timeout       time.Duration
+	NoWait        bool
+	Yes           bool
+}
+
+func MakeCommandLineLifecycleConfig(action string) LifecycleConfig {
+	lifecycleCmd.Init(action, flag.ExitOnError)
+	lifecycleCmd.Parse(os.Args[2:])
+	awsOptions := awsOptionsLifecycleParam.makeAwsOptions()
+
+	return LifecycleConfig{
+		Action:        action,
+		AwsProfile:    getAwsLifecycleProfile(*awsProfileLifecycleParam),
+		Machine:       getMachine(*machineDataLifecycleParam),
+		AssumeRole:    MakeAssumeRole(*roleArnLifecycleParam, *externalIdLifecycleParam, *roleSessionNameLifecycleParam),
+		AwsOptions:    awsOptions,
+		InventoryFile: getCacheFile(lifecycleCmd, "inventory-file", *inventoryLifecycleParam, awsOptions),
+		Timeout:       *timeoutLifecycleParam,
+		NoWait:        *noWaitParam,
+		Yes:           *yesLifecycleParam,
+	}
+}
+
+func getAwsLifecycleProfile(profile string) string {
+	if profile != "" {
+		return profile
+	}
+
+	return os.Getenv("AWS_PROFILE")
+}
@@ -6,24 +6,82 @@ import (
 	"log"
 )
 
-const NoMachineName = "Unknown"
 const NoUserName = "Unknown"
 
+// The values of the connect mode tag
+const (
+	SshConnectMode = "ssh"
+	SsmConnectMode = "ssm"
+)
+
 var NoMachine = Machine{}
 var NoBastion = BastionMachine{}
 
+// Machines without a Keyfile are connected with the ssh defaults, or with connect --instance-connect.
+// PrivateZone is set when the DnsName comes from a private hosted zone, which resolves only in its VPCs
 type Machine struct {
+	Id          string
+	Region      string
+	AccountId   string
+	Role        AssumeRole
+	Name        string
+	User        string
+	Keyfile     string `json:",omitempty"`
+	Bastions    []BastionMachine
+	Address     string                   `json:",omitempty"`
+	DnsName     string                   `json:",omitempty"`
+	PrivateZone bool                     `json:",omitempty"`
+	Endpoint    *InstanceConnectEndpoint `json:",omitempty"`
+	Ssm         bool                     `json:",omitempty"`
+}
+
+// An EC2 Instance Connect Endpoint in the machine VPC, connect may tunnel ssh through it instead of a bastion
+type InstanceConnectEndpoint struct {
 	Id      string
-	Name    string
-	User    string
-	Keyfile string
-	Bastion BastionMachine
+	DnsName string
 }
 
+// A bastion referenced by InstanceId or Name has no Url, it is resolved by connect. The Name is looked up
+// in the NameTags given to generate.
+// Fallbacks are other candidates for the same hop, tried in order when the bastion is down
 type BastionMachine struct {
-	Url     string
-	User    string
-	Keyfile string
+	Url        string
+	User       string
+	Keyfile    string
+	InstanceId string           `json:",omitempty"`
+	Name       string           `json:",omitempty"`
+	NameTags   []string         `json:",omitempty"`
+	Fallbacks  []BastionMachine `json:",omitempty"`
+}
+
+func (bastion BastionMachine) IsEmpty() bool {
+	return bastion.Url == "" && bastion.InstanceId == "" && bastion.Name == ""
+}
+
+func (bastion BastionMachine) IsReference() bool {
+	return bastion.Url == "" && (bastion.InstanceId != "" || bastion.Name != "")
+}
+
+// Machine data generated before NameTags looks up the Name tag
+func (bastion BastionMachine) GetNameTags() []string {
+	if len(bastion.NameTags) == 0 {
+		return []string{"Name"}
+	}
+
+	return bastion.NameTags
+}
+
+func (bastion BastionMachine) Candidates() []BastionMachine {
+	primary := bastion
+	primary.Fallbacks = nil
+
+	return append([]BastionMachine{primary}, bastion.Fallbacks...)
+}
+
+// Machine data of functions generated before bastion chains has a single Bastion
+type machineData struct {
+	Machine
+	Bastion BastionMachine
 }
 
 func SerializeMachine(machine Machine) string {
@@ -45,12 +103,16 @@ func DeserializeMachine(serializedMachine string) (Machine, error) {
 		return NoMachine, err
 	}
 
-	machine := Machine{}
+	machine := machineData{}
 
 	if err := json.Unmarshal(jsonBytes, &machine); err != nil {
 		log.Printf("Error unmarshalling machine %v", jsonBytes)
 		return NoMachine, err
 	}
 
-	return machine, nil
+	if len(machine.Bastions) == 0 && !machine.Bastion.IsEmpty() {
+		machine.Bastions = []BastionMachine{machine.Bastion}
+	}
+
+	return machine.Machine, nil
 }
@@ -0,0 +1,183 @@
+package model
+
+import (
+	"errors"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const tagRulePrefix = "tag:"
+
+// An EC2 API filter, e.g. tag:Env=prod or instance-type=t3.*, the values of a filter are ORed
+type Ec2Filter struct {
+	Name   string
+	Values []string
+}
+
+// A client side rule on the machine name, e.g. web-*, or on a tag value, e.g. tag:Team=infra.
+// Patterns are globs, or regular expressions when wrapped in slashes, e.g. /^web-[0-9]+$/
+type MachineRule struct {
+	Rule    string
+	TagKey  string
+	Pattern string
+	regexp  *regexp.Regexp
+	err     error
+}
+
+func makeEc2Filters(filters string) []Ec2Filter {
+	ec2Filters := []Ec2Filter{}
+
+	for _, filter := range splitRuleList(filters) {
+		name, value, found := strings.Cut(filter, "=")
+
+		if !found {
+			ec2Filters = append(ec2Filters, Ec2Filter{Values: []string{filter}})
+			continue
+		}
+
+		ec2Filters = addEc2FilterValue(ec2Filters, strings.TrimSpace(name), value)
+	}
+
+	return ec2Filters
+}
+
+// Filters with the same name are merged, e.g. tag:Env=prod,tag:Env=staging matches both
+func addEc2FilterValue(filters []Ec2Filter, name string, value string) []Ec2Filter {
+	for i := range filters {
+		if filters[i].Name == name {
+			filters[i].Values = append(filters[i].Values, value)
+			return filters
+		}
+	}
+
+	return append(filters, Ec2Filter{Name: name, Values: []string{value}})
+}
+
+func MakeMachineRules(rules string) []MachineRule {
+	machineRules := []MachineRule{}
+
+	for _, rule := range splitRuleList(rules) {
+		machineRules = append(machineRules, makeMachineRule(rule))
+	}
+
+	return machineRules
+}
+
+// Splits a comma separated list of rules or filters. A comma inside a /regexp/ pattern doesn't split, e.g.
+// /^web-[0-9]{1,3}$/, and \, is a literal comma elsewhere, e.g. tag:Owner=Smith\, John
+func splitRuleList(list string) []string {
+	values := []string{}
+	var current strings.Builder
+	inRegexp := false
+
+	for i := 0; i < len(list); i++ {
+		c := list[i]
+		atEnd := i+1 == len(list)
+
+		switch {
+		case inRegexp:
+			current.WriteByte(c)
+			inRegexp = !(c == '/' && (atEnd || list[i+1] == ','))
+		case c == '\\' && !atEnd && list[i+1] == ',':
+			current.WriteByte(',')
+			i++
+		case c == ',':
+			values = appendNonEmpty(values, current.String())
+			current.Reset()
+		case c == '/' && isPatternStart(current.String()):
+			current.WriteByte(c)
+			inRegexp = true
+		default:
+			current.WriteByte(c)
+		}
+	}
+
+	return appendNonEmpty(values, current.String())
+}
+
+// A pattern starts a rule, or follows the = of a tag rule or of a filter
+func isPatternStart(rule string) bool {
+	rule = strings.TrimSpace(rule)
+	return rule == "" || (strings.HasSuffix(rule, "=") && strings.Count(rule, "=") == 1)
+}
+
+func appendNonEmpty(values []string, value string) []string {
+	if value = strings.TrimSpace(value); value != "" {
+		return append(values, value)
+	}
+
+	return values
+}
+
+func makeMachineRule(rule string) MachineRule {
+	machineRule := MachineRule{
+		Rule:    rule,
+		Pattern: rule,
+	}
+
+	if strings.HasPrefix(rule, tagRulePrefix) {
+		tagKey, pattern, found := strings.Cut(strings.TrimPrefix(rule, tagRulePrefix), "=")
+		machineRule.TagKey = tagKey
+
+		// tag:Key without a pattern matches any value of the tag
+		//
+		if found {
+			machineRule.Pattern = pattern
+		} else {
+			machineRule.Pattern = "*"
+		}
+	}
+
+	if len(machineRule.Pattern) > 1 && strings.HasPrefix(machineRule.Pattern, "/") && strings.HasSuffix(machineRule.Pattern, "/") {
+		machineRule.regexp, machineRule.err = regexp.Compile(machineRule.Pattern[1 : len(machineRule.Pattern)-1])
+	} else {
+		_, machineRule.err = path.Match(machineRule.Pattern, "")
+	}
+
+	if machineRule.err == nil && rule == "" {
+		machineRule.err = errors.New("empty rule")
+	}
+
+	return machineRule
+}
+
+func (rule MachineRule) Valid() bool {
+	if rule.err != nil {
+		log.Printf("Invalid rule %v, %v", rule.Rule, rule.err)
+		return false
+	}
+
+	return true
+}
+
+func (rule MachineRule) Matches(name string, tags map[string]string) bool {
+	value := name
+
+	if rule.TagKey != "" {
+		tagValue, found := tags[rule.TagKey]
+
+		if !found {
+			return false
+		}
+
+		value = tagValue
+	}
+
+	if rule.regexp != nil {
+		return rule.regexp.MatchString(value)
+	}
+
+	matched, _ := path.Match(rule.Pattern, value)
+	return matched
+}
+
+func (filter Ec2Filter) Valid() bool {
+	if filter.Name == "" {
+		log.Printf("Invalid EC2 filter %v, expected name=value", strings.Join(filter.Values, ","))
+		return false
+	}
+
+	return true
+}
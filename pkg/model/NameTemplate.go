@@ -0,0 +1,89 @@
+package model
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"text/template"
+)
+
+// The fields available to --name-template, tags are accessed by key, e.g. {{ .Tags.Env }}-{{ .Name }},
+// or with index for keys that aren't identifiers, e.g. {{ index .Tags "aws:autoscaling:groupName" }}
+type MachineNameData struct {
+	Name             string
+	Tags             map[string]string
+	AccountId        string
+	Region           string
+	AvailabilityZone string
+	InstanceId       string
+	InstanceType     string
+	PrivateIp        string
+	DnsName          string
+}
+
+type NameTemplate struct {
+	Text     string
+	template *template.Template
+	err      error
+}
+
+var nameTemplateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"default": func(fallback string, value string) string {
+		if value == "" {
+			return fallback
+		}
+
+		return value
+	},
+}
+
+func makeNameTemplate(text string) NameTemplate {
+	nameTemplate := NameTemplate{Text: text}
+
+	if text == "" {
+		return nameTemplate
+	}
+
+	nameTemplate.template, nameTemplate.err = template.New("machine name").
+		Option("missingkey=zero").
+		Funcs(nameTemplateFuncs).
+		Parse(text)
+
+	// A dry run catches unknown fields before loading the machines
+	//
+	if nameTemplate.err == nil {
+		_, nameTemplate.err = nameTemplate.Execute(MachineNameData{})
+	}
+
+	return nameTemplate
+}
+
+func (nameTemplate NameTemplate) Valid() bool {
+	if nameTemplate.err != nil {
+		log.Printf("Invalid name template %v, %v", nameTemplate.Text, nameTemplate.err)
+		return false
+	}
+
+	return true
+}
+
+func (nameTemplate NameTemplate) IsSet() bool {
+	return nameTemplate.template != nil
+}
+
+// Separators left by empty fields are trimmed, e.g. "-web1" when the Env tag is missing
+func (nameTemplate NameTemplate) Execute(data MachineNameData) (string, error) {
+	if nameTemplate.template == nil {
+		return "", errors.New("name template is not set")
+	}
+
+	var sb strings.Builder
+
+	if err := nameTemplate.template.Execute(&sb, data); err != nil {
+		return "", err
+	}
+
+	return strings.Trim(strings.TrimSpace(sb.String()), "-_."), nil
+}
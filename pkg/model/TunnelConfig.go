@@ -0,0 +1,52 @@
+package model
+
+import (
+	"flag"
+	"os"
+	"time"
+)
+
+// The hidden subcommand which connect runs as the ssh proxy command of an instance connect endpoint
+const TunnelCommand = "eice-tunnel"
+
+var (
+	tunnelCmd = flag.NewFlagSet(TunnelCommand, flag.ExitOnError)
+
+	awsProfileTunnelParam      = tunnelCmd.String("profile", "", "AWS Cli Profile to use")
+	regionTunnelParam          = tunnelCmd.String("region", "", "The region of the endpoint")
+	endpointIdParam            = tunnelCmd.String("endpoint-id", "", "The instance connect endpoint id")
+	endpointDnsParam           = tunnelCmd.String("endpoint-dns", "", "The DNS name of the instance connect endpoint")
+	addressTunnelParam         = tunnelCmd.String("address", "", "The private IP address of the machine")
+	portParam                  = tunnelCmd.String("port", "22", "The port of the machine")
+	maxDurationParam           = tunnelCmd.Duration("max-duration", 0, "Maximum duration of the tunnel (default is the endpoint maximum, 1h)")
+	roleArnTunnelParam         = tunnelCmd.String("role-arn", "", "IAM role ARN to assume")
+	externalIdTunnelParam      = tunnelCmd.String("external-id", "", "External ID to use when assuming the role")
+	roleSessionNameTunnelParam = tunnelCmd.String("role-session-name", DefaultRoleSessionName, "Session name to use when assuming the role")
+	awsOptionsTunnelParam      = addAwsOptionsParams(tunnelCmd)
+)
+
+type TunnelConfig struct {
+	AwsProfile  string
+	Region      string
+	Endpoint    InstanceConnectEndpoint
+	Address     string
+	Port        string
+	MaxDuration time.Duration
+	AssumeRole  AssumeRole
+	AwsOptions  AwsOptions
+}
+
+func MakeCommandLineTunnelConfig() TunnelConfig {
+	tunnelCmd.Parse(os.Args[2:])
+
+	return TunnelConfig{
+		AwsProfile:  *awsProfileTunnelParam,
+		Region:      *regionTunnelParam,
+		Endpoint:    InstanceConnectEndpoint{Id: *endpointIdParam, DnsName: *endpointDnsParam},
+		Address:     *addressTunnelParam,
+		Port:        *portParam,
+		MaxDuration: *maxDurationParam,
+		AssumeRole:  MakeAssumeRole(*roleArnTunnelParam, *externalIdTunnelParam, *roleSessionNameTunnelParam),
+		AwsOptions:  awsOptionsTunnelParam.makeAwsOptions(),
+	}
+}
@@ -20,6 +20,8 @@ type bashFunction struct {
 	AwsbasshExec string
 	AwsProfile   string
 	ForceBastion bool
+	AwsOptions   model.AwsOptions
+	Inventory    string
 }
 
 func WriteMachines(config model.GenerateConfig, machines map[string]model.Machine) bool {
@@ -95,11 +97,13 @@ func parseBashFunctionTemplate() (*template.Template, error) {
 
 func getMachineFunction(config model.GenerateConfig, machine model.Machine, duplications map[string]int) bashFunction {
 	return bashFunction{
-		FunctionName: normalizeMachineName(config.BashAliasPrefix + getMachineName(machine, duplications)),
+		FunctionName: normalizeMachineName(config.BashAliasPrefix+getMachineName(machine, duplications), isRoute53Name(config, machine)),
 		MachineData:  model.SerializeMachine(machine),
 		AwsbasshExec: getAwsbasshPath(),
 		AwsProfile:   config.AwsProfile,
 		ForceBastion: config.ForceBastion,
+		AwsOptions:   config.AwsOptions,
+		Inventory:    config.InventoryFile,
 	}
 }
 
@@ -111,8 +115,16 @@ func getMachineName(machine model.Machine, duplications map[string]int) string {
 	}
 }
 
-func normalizeMachineName(machineName string) string {
-	reg, err := regexp.Compile("[^a-zA-Z_0-9-]")
+// Bash accepts dots in function names, so the Route 53 names of --route53-names are kept as they are,
+// other names are normalized like before, e.g. web.prod is ec2_web_prod
+func normalizeMachineName(machineName string, keepDots bool) string {
+	pattern := "[^a-zA-Z_0-9-]"
+
+	if keepDots {
+		pattern = "[^a-zA-Z_0-9.-]"
+	}
+
+	reg, err := regexp.Compile(pattern)
 
 	if err != nil {
 		log.Printf("Error compiling machine name normailizer regex %v", err)
@@ -122,6 +134,10 @@ func normalizeMachineName(machineName string) string {
 	return reg.ReplaceAllString(machineName, "_")
 }
 
+func isRoute53Name(config model.GenerateConfig, machine model.Machine) bool {
+	return config.Route53Names && machine.DnsName != "" && machine.Name == machine.DnsName
+}
+
 func getAwsbasshPath() string {
 	if !strings.HasPrefix(os.Args[0], ".") {
 		return os.Args[0]
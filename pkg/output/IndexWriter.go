@@ -0,0 +1,46 @@
+package output
+
+import (
+	"aws-bassh/pkg/model"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+func WriteIndexFile(config model.GenerateConfig, files []string) bool {
+	outputFile := openOutputFile(config)
+
+	if outputFile == nil {
+		return false
+	}
+
+	defer outputFile.Close()
+
+	writeFileHeader(outputFile)
+
+	for _, file := range files {
+		outputFile.WriteString("source " + getSourcePath(file) + "\n")
+	}
+
+	return true
+}
+
+func CreateProfilesDir(config model.GenerateConfig) bool {
+	if err := os.MkdirAll(config.ProfilesDir, 0755); err != nil {
+		log.Printf("Error creating profiles output directory %v, %v", config.ProfilesDir, err)
+		return false
+	}
+
+	return true
+}
+
+func getSourcePath(file string) string {
+	path, err := filepath.Abs(file)
+
+	if err != nil {
+		log.Printf("Error getting absolute path of %v", file)
+		return file
+	}
+
+	return path
+}
@@ -1,7 +1,27 @@
 function {{ .FunctionName }}() {
-	{{ .AwsbasshExec }} connect --profile "{{ .AwsProfile }}" \
+	local command="connect"
+
+	case "$1" in
+		start|stop|reboot|status)
+			command="$1"
+			shift
+			;;
+	esac
+
+	local connect_args=()
+
+	if [ "$command" == "connect" ]; then
+		connect_args=({{ if .ForceBastion }} --force-bastion {{ end }})
+	fi
+
+	{{ .AwsbasshExec }} "$command" --profile "{{ .AwsProfile }}" \
 		--machine-data "{{ .MachineData }}" \
-		{{ if .ForceBastion }} --force-bastion {{ end }} \
+		--inventory-file "{{ .Inventory }}" \
+		{{ if .AwsOptions.Ec2Fixture }} --ec2-fixture "{{ .AwsOptions.Ec2Fixture }}" {{ end }} \
+		{{ if .AwsOptions.EndpointUrl }} --endpoint-url "{{ .AwsOptions.EndpointUrl }}" {{ end }} \
+		{{ if .AwsOptions.SkipCredentialsCheck }} --skip-credentials-check {{ end }} \
+		{{ if .AwsOptions.SsoLogin }} --sso-login {{ end }} \
+		"${connect_args[@]}" \
 		"$@"
 }
 